@@ -1,31 +1,391 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gomaker/internal/builder"
+	"gomaker/internal/config"
 )
 
+const usage = `Usage: gomaker <command> [flags] <map> [basepath]
+
+Commands:
+  build    build <map>.pk3 from the map and everything it depends on
+  deps     list the files a build would pack without writing a pk3
+  check    resolve every dependency and compare map and bsp shaders
+  inspect  list the files inside a pk3: gomaker inspect <pk3>
+  diff     compare a pk3 with what a build would pack: gomaker diff <pk3> <map> [basepath]
+
+The map and base path can also come from a -config file, or default to the MAPNAME
+and Q3_BASEPATH environment variables. A maps/<map>.gomaker.json next to the map is
+read automatically.
+Run gomaker <command> -h for the flags of a command.
+`
+
+var errUsage = errors.New("usage")
+
+type patterns []string
+
+type sourceFlag struct {
+	source *builder.Source
+}
+
+type command struct {
+	flags    *flag.FlagSet
+	options  builder.Options
+	basePath string
+	json     bool
+}
+
 func main() {
+	args := os.Args[1:]
+	name := "build"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "build", "deps", "check", "inspect", "diff":
+			name = args[0]
+			args = args[1:]
+		case "help":
+			fmt.Print(usage)
+			return
+		}
+	}
+
+	var err error
+	switch name {
+	case "build":
+		err = runBuild(args)
+	case "deps":
+		err = runDeps(args)
+	case "check":
+		err = runCheck(args)
+	case "inspect":
+		err = runInspect(args)
+	case "diff":
+		err = runDiff(args)
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gomaker %s failed: %s\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func newCommand(name string) *command {
+	cmd := &command{flags: flag.NewFlagSet(name, flag.ContinueOnError)}
+	cmd.flags.StringVar(
+		&cmd.basePath,
+		"basepath",
+		os.Getenv("Q3_BASEPATH"),
+		"game directory containing the map, e.g. baseq3",
+	)
+	cmd.flags.StringVar(
+		&cmd.options.FsGame,
+		"fs_game",
+		"",
+		"mod directory next to the base path searched before it, e.g. cpma or defrag, defaults to Q3_FSGAME",
+	)
+	cmd.flags.Var(
+		sourceFlag{&cmd.options.Source},
+		"source",
+		"read dependencies from the map, the bsp or auto",
+	)
+	cmd.flags.BoolVar(
+		&cmd.options.UseShaderList,
+		"shaderlist",
+		false,
+		"only load shader files listed in scripts/shaderlist.txt",
+	)
+	cmd.flags.BoolVar(
+		&cmd.options.TrimShaders,
+		"trim-shaders",
+		false,
+		"pack a shader file with only the shaders the map uses",
+	)
+	cmd.flags.Var((*patterns)(&cmd.options.Include), "include", "glob of extra files to pack, repeatable")
+	cmd.flags.Var(
+		(*patterns)(&cmd.options.Exclude),
+		"exclude",
+		"glob of files to leave out, repeatable, wins over include",
+	)
+	cmd.flags.BoolVar(&cmd.options.Verbose, "v", false, "print every file that is packed")
+	cmd.flags.BoolVar(&cmd.json, "json", false, "print the result as JSON")
+	cmd.flags.StringVar(
+		&cmd.options.ConfigPath,
+		"config",
+		"",
+		"map config file, defaults to maps/<map>.gomaker.json in the game directories",
+	)
+	return cmd
+}
+
+// Takes the map name and an optional base path from the positional arguments,
+// then from the -config file, and finally from MAPNAME and the -basepath flag.
+// The fs_game of the config, or of the one found next to the map, beats
+// Q3_FSGAME but not an explicit -fs_game.
+func (cmd *command) parse(args []string) (string, error) {
+	err := cmd.flags.Parse(args)
+	if err != nil {
+		return "", err
+	}
+	// Builder progress goes to stderr in JSON mode so stdout stays parseable.
+	if cmd.json {
+		cmd.options.Output = os.Stderr
+	}
+
+	mapName := os.Getenv("MAPNAME")
+	mapConfig := config.Config{}
+	if len(cmd.options.ConfigPath) > 0 {
+		mapConfig, err = config.Read(cmd.options.ConfigPath)
+		if err != nil {
+			return "", err
+		}
+		if len(mapConfig.Map) > 0 {
+			mapName = mapConfig.Map
+		}
+		if len(mapConfig.BasePath) > 0 && !cmd.isSet("basepath") {
+			cmd.basePath = mapConfig.BasePath
+			if !filepath.IsAbs(cmd.basePath) {
+				cmd.basePath = filepath.Join(filepath.Dir(cmd.options.ConfigPath), cmd.basePath)
+			}
+		}
+	}
+	if cmd.flags.NArg() > 0 {
+		mapName = cmd.flags.Arg(0)
+	}
+	if cmd.flags.NArg() > 1 {
+		cmd.basePath = cmd.flags.Arg(1)
+	}
+	if len(mapName) == 0 || len(cmd.basePath) == 0 || cmd.flags.NArg() > 2 {
+		return "", errUsage
+	}
+
+	if !cmd.isSet("fs_game") {
+		cmd.options.FsGame = os.Getenv("Q3_FSGAME")
+		directories := builder.GameDirectories(cmd.basePath, cmd.options.FsGame)
+		found, ok := config.Find(directories, mapName)
+		if len(cmd.options.ConfigPath) == 0 && ok {
+			cmd.options.ConfigPath = found
+			mapConfig, err = config.Read(found)
+			if err != nil {
+				return "", err
+			}
+		}
+		if len(mapConfig.FsGame) > 0 {
+			cmd.options.FsGame = mapConfig.FsGame
+		}
+	}
+	return mapName, nil
+}
+
+func (cmd *command) isSet(name string) bool {
+	set := false
+	cmd.flags.Visit(func(found *flag.Flag) {
+		set = set || found.Name == name
+	})
+	return set
+}
+
+func runBuild(args []string) error {
 	start := time.Now()
+	cmd := newCommand("build")
+	cmd.flags.StringVar(
+		&cmd.options.OutputPath,
+		"o",
+		"",
+		"pk3 file or directory to write to, defaults to the current directory",
+	)
+	cmd.flags.BoolVar(
+		&cmd.options.Install,
+		"install",
+		false,
+		"write the pk3 into the game directory so it loads immediately",
+	)
+	cmd.flags.BoolVar(&cmd.options.Verify, "verify", false, "compare map and bsp shaders before building")
+	cmd.flags.BoolVar(
+		&cmd.options.FailOnMismatch,
+		"fail-on-mismatch",
+		false,
+		"refuse to build when map and bsp shaders differ",
+	)
+	mapName, err := cmd.parse(args)
+	if err != nil {
+		return err
+	}
 
-	if len(os.Args[1:]) > 1 {
-		mapName := os.Args[1]
-		basePath := os.Args[2]
-		pk3Path := builder.BuildPk3(mapName, basePath)
-		fmt.Printf("Pk3 built and copied to %s\n", pk3Path)
+	pk3Path, err := builder.BuildPk3WithOptions(mapName, cmd.basePath, cmd.options)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.options.Writer(), "Elapsed time", time.Since(start))
+	if cmd.json {
+		return printJSON(struct{ Map, Pk3 string }{mapName, pk3Path})
+	}
+	fmt.Printf("Pk3 written to %s\n", pk3Path)
+	return nil
+}
+
+func runDeps(args []string) error {
+	cmd := newCommand("deps")
+	mapName, err := cmd.parse(args)
+	if err != nil {
+		return err
+	}
+
+	contents, err := builder.Dependencies(mapName, cmd.basePath, cmd.options)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return printJSON(contents)
+	}
+	builder.PrintContents(os.Stdout, contents)
+	builder.PrintPatternReport(os.Stdout, contents.Patterns)
+	builder.PrintArchivedResources(os.Stdout, contents.Archived)
+	return nil
+}
+
+func runCheck(args []string) error {
+	cmd := newCommand("check")
+	mapName, err := cmd.parse(args)
+	if err != nil {
+		return err
+	}
+
+	report, err := builder.Check(mapName, cmd.basePath, cmd.options)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		err = printJSON(report)
+	} else if report.Compared {
+		builder.PrintShaderMismatch(os.Stdout, mapName, report.Mismatch)
+	} else {
+		fmt.Printf("All dependencies of %s resolved, no .map and .bsp pair to compare\n", mapName)
+	}
+	if err == nil && report.Mismatch.HasMismatch() {
+		return fmt.Errorf("%w for %s", builder.ErrShaderMismatch, mapName)
+	}
+	return err
+}
+
+func runInspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	entries, err := builder.InspectPk3(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(entries)
+	}
+	for _, entry := range entries {
+		fmt.Printf("%10d  %s  %s\n", entry.Size, entry.Modified.Format(time.DateTime), entry.Name)
+	}
+	fmt.Printf("%d files\n", len(entries))
+	return nil
+}
+
+func runDiff(args []string) error {
+	cmd := newCommand("diff")
+	err := cmd.flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if cmd.flags.NArg() < 1 {
+		return errUsage
+	}
+	pk3Path := cmd.flags.Arg(0)
+	mapName, err := cmd.parse(cmd.flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	entries, err := builder.InspectPk3(pk3Path)
+	if err != nil {
+		return err
+	}
+	contents, err := builder.Dependencies(mapName, cmd.basePath, cmd.options)
+	if err != nil {
+		return err
+	}
+
+	diff := builder.DiffPk3(contents, entries)
+	if cmd.json {
+		err = printJSON(diff)
 	} else {
-		mapName := os.Getenv("MAPNAME")
-		basePath := os.Getenv("Q3_BASEPATH")
-		if len(mapName) == 0 || len(basePath) == 0 {
-			fmt.Println("Either pass map name and base path as arguments, or export env variables MAPNAME and Q3_BASEPATH")
-		} else {
-			pk3Path := builder.BuildPk3(mapName, basePath)
-			fmt.Printf("Pk3 built and copied to %s\n", pk3Path)
+		for _, missing := range diff.Missing {
+			fmt.Printf("- %s\n", missing)
+		}
+		for _, extra := range diff.Extra {
+			fmt.Printf("+ %s\n", extra)
+		}
+	}
+	if err == nil && diff.HasDifference() {
+		return fmt.Errorf(
+			"%s differs from %s: %d missing, %d extra",
+			pk3Path,
+			mapName,
+			len(diff.Missing),
+			len(diff.Extra),
+		)
+	}
+	return err
+}
+
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func (values *patterns) String() string {
+	return strings.Join(*values, ",")
+}
+
+func (values *patterns) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if len(pattern) > 0 {
+			*values = append(*values, pattern)
 		}
 	}
-	elapsed := time.Since(start)
-	fmt.Println("Elapsed time", elapsed)
+	return nil
+}
+
+func (value sourceFlag) String() string {
+	if value.source == nil {
+		return builder.SourceAuto.String()
+	}
+	return value.source.String()
+}
+
+func (value sourceFlag) Set(source string) error {
+	parsed, err := builder.ParseSource(source)
+	if err != nil {
+		return err
+	}
+	*value.source = parsed
+	return nil
 }
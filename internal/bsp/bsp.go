@@ -0,0 +1,168 @@
+package bsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	LumpEntities = 0
+	LumpShaders  = 1
+
+	shaderNameLength = 64
+	shaderSize       = shaderNameLength + 8
+)
+
+type format struct {
+	ident    string
+	version  int32
+	numLumps int
+}
+
+var formats = []format{
+	{"IBSP", 46, 17},
+	{"RBSP", 1, 18},
+}
+
+type Lump struct {
+	Offset int32
+	Length int32
+}
+
+type Shader struct {
+	Name         string
+	SurfaceFlags int32
+	ContentFlags int32
+}
+
+type Bsp struct {
+	Ident    string
+	Version  int32
+	Lumps    []Lump
+	Shaders  []Shader
+	Entities string
+}
+
+func ReadFile(path string) (*Bsp, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bsp, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return bsp, nil
+}
+
+func ReadFS(files fs.FS, name string) (*Bsp, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bsp, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return bsp, nil
+}
+
+func Read(reader io.Reader) (*Bsp, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("file too short for a bsp header (%d bytes)", len(data))
+	}
+
+	bsp := &Bsp{
+		Ident:   string(data[:4]),
+		Version: int32(binary.LittleEndian.Uint32(data[4:8])),
+	}
+	numLumps, err := lumpCount(bsp.Ident, bsp.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := 8 + numLumps*8
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("file too short for %d lumps (%d bytes)", numLumps, len(data))
+	}
+	bsp.Lumps = make([]Lump, numLumps)
+	err = binary.Read(bytes.NewReader(data[8:headerSize]), binary.LittleEndian, bsp.Lumps)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := lumpData(data, bsp.Lumps, LumpEntities)
+	if err != nil {
+		return nil, err
+	}
+	bsp.Entities = string(bytes.TrimRight(entities, "\x00"))
+
+	shaders, err := lumpData(data, bsp.Lumps, LumpShaders)
+	if err != nil {
+		return nil, err
+	}
+	bsp.Shaders, err = readShaders(shaders)
+	if err != nil {
+		return nil, err
+	}
+	return bsp, nil
+}
+
+func (bsp *Bsp) ShaderNames() []string {
+	names := []string{}
+	for _, shader := range bsp.Shaders {
+		names = append(names, shader.Name)
+	}
+	return names
+}
+
+func lumpCount(ident string, version int32) (int, error) {
+	for _, format := range formats {
+		if format.ident == ident {
+			if format.version != version {
+				return 0, fmt.Errorf("unsupported %s version %d, expected %d", ident, version, format.version)
+			}
+			return format.numLumps, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported bsp ident %q", ident)
+}
+
+func lumpData(data []byte, lumps []Lump, index int) ([]byte, error) {
+	lump := lumps[index]
+	start := int64(lump.Offset)
+	end := start + int64(lump.Length)
+	if lump.Offset < 0 || lump.Length < 0 || end > int64(len(data)) {
+		return nil, fmt.Errorf("lump %d out of bounds (offset %d, length %d)", index, lump.Offset, lump.Length)
+	}
+	return data[start:end], nil
+}
+
+func readShaders(data []byte) ([]Shader, error) {
+	if len(data)%shaderSize != 0 {
+		return nil, fmt.Errorf("shaders lump size %d is not a multiple of %d", len(data), shaderSize)
+	}
+	shaders := make([]Shader, 0, len(data)/shaderSize)
+	for offset := 0; offset < len(data); offset += shaderSize {
+		record := data[offset : offset+shaderSize]
+		name, _, _ := bytes.Cut(record[:shaderNameLength], []byte{0})
+		shaders = append(shaders, Shader{
+			Name:         string(name),
+			SurfaceFlags: int32(binary.LittleEndian.Uint32(record[shaderNameLength:])),
+			ContentFlags: int32(binary.LittleEndian.Uint32(record[shaderNameLength+4:])),
+		})
+	}
+	return shaders, nil
+}
@@ -2,270 +2,557 @@ package builder
 
 import (
 	"archive/zip"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"gomaker/internal/material"
+	"gomaker/internal/pak"
 	"gomaker/internal/parser"
+	"gomaker/internal/shader"
+	"gomaker/internal/vfs"
 )
 
-func BuildPk3(mapName string, basePath string) string {
-	resources := []string{}
+type Source int
+
+const (
+	SourceAuto Source = iota
+	SourceMap
+	SourceBsp
+)
+
+var (
+	ErrMapNotFound    = parser.ErrMapNotFound
+	ErrMissingAsset   = material.ErrMissingAsset
+	ErrShaderMismatch = errors.New("map and bsp shaders do not match")
+)
+
+type ArchivedResource struct {
+	Resource string
+	Archive  string
+}
+
+type Options struct {
+	Source         Source
+	Verify         bool
+	FailOnMismatch bool
+	UseShaderList  bool
+	TrimShaders    bool
+	StockArchives  []string
+	FsGame         string
+	OutputPath     string
+	Install        bool
+	Include        []string
+	Exclude        []string
+	Verbose        bool
+	Pk3Name        string
+	ConfigPath     string
+	Output         io.Writer
+}
+
+type Contents struct {
+	Resources []string
+	Generated map[string]string
+	Archived  []ArchivedResource
+	Patterns  PatternReport
+}
 
-	resource := GetFile(basePath, fmt.Sprintf("%s.txt", mapName))
-	if len(resource) > 0 {
-		resources = append(resources, resource)
+func BuildPk3(mapName string, basePath string) (string, error) {
+	return BuildPk3WithOptions(mapName, basePath, Options{})
+}
+
+func BuildPk3WithOptions(mapName string, basePath string, options Options) (string, error) {
+	options, err := LoadOptions(mapName, basePath, options)
+	if err != nil {
+		return "", err
 	}
 
-	resource = GetFile(basePath, fmt.Sprintf("cfg-maps/%s.cfg", mapName))
-	if len(resource) > 0 {
-		resources = append(resources, resource)
+	pk3Path, err := Pk3Path(mapName, basePath, options)
+	if err != nil {
+		return "", err
 	}
 
-	resource = GetFile(basePath, fmt.Sprintf("maps/%s.map", mapName))
-	if len(resource) > 0 {
-		resources = append(resources, resource)
+	directories := GameDirectories(basePath, options.FsGame)
+	files, err := vfs.OpenExcluding([]string{pk3Path}, directories...)
+	if err != nil {
+		return "", fmt.Errorf("opening game directories: %w", err)
 	}
+	defer files.Close()
 
-	resource = GetFile(basePath, fmt.Sprintf("maps/%s.bsp", mapName))
-	if len(resource) > 0 {
-		resources = append(resources, resource)
+	contents, err := ReadContents(mapName, basePath, files, options)
+	if err != nil {
+		return "", err
 	}
+	if options.Verbose {
+		PrintContents(options.Writer(), contents)
+	}
+	PrintPatternReport(options.Writer(), contents.Patterns)
+	PrintArchivedResources(options.Writer(), contents.Archived)
 
-	resource = GetFile(basePath, fmt.Sprintf("scripts/%s.arena", mapName))
-	if len(resource) > 0 {
-		resources = append(resources, resource)
+	pk3Path, err = CreatePk3WithGeneratedFiles(files, contents.Resources, contents.Generated, pk3Path)
+	if err != nil {
+		return "", err
 	}
+	fmt.Fprintf(options.Writer(), "Created pk3 %s\n", pk3Path)
+	return pk3Path, nil
+}
 
-	resource = GetFile(basePath, fmt.Sprintf("levelshots/%s.jpg", mapName))
-	if len(resource) > 0 {
-		resources = append(resources, resource)
-	} else {
-		resource = GetFile(basePath, fmt.Sprintf("levelshots/%s.tga", mapName))
-		if len(resource) > 0 {
-			resources = append(resources, resource)
+// Progress messages go to Output, or to stdout when it is not set.
+func (options Options) Writer() io.Writer {
+	if options.Output == nil {
+		return os.Stdout
+	}
+	return options.Output
+}
+
+// Collects what BuildPk3 would pack without writing anything.
+func Dependencies(mapName string, basePath string, options Options) (Contents, error) {
+	options, err := LoadOptions(mapName, basePath, options)
+	if err != nil {
+		return Contents{}, err
+	}
+
+	directories := GameDirectories(basePath, options.FsGame)
+	files, err := vfs.Open(directories...)
+	if err != nil {
+		return Contents{}, fmt.Errorf("opening game directories: %w", err)
+	}
+	defer files.Close()
+
+	return ReadContents(mapName, basePath, files, options)
+}
+
+func ReadContents(mapName string, basePath string, files *vfs.FS, options Options) (Contents, error) {
+	if options.Verify || options.FailOnMismatch {
+		mismatch, err := VerifyBsp(mapName, files)
+		if err != nil && options.FailOnMismatch {
+			return Contents{}, fmt.Errorf("comparing %s.map and %s.bsp: %w", mapName, mapName, err)
+		}
+		if err != nil {
+			fmt.Fprintf(options.Writer(), "Could not compare map and bsp shaders: %s\n", err)
+		} else {
+			PrintShaderMismatch(options.Writer(), mapName, mismatch)
+		}
+		if options.FailOnMismatch && mismatch.HasMismatch() {
+			return Contents{}, fmt.Errorf("%w for %s", ErrShaderMismatch, mapName)
+		}
+	}
+
+	var err error
+	resources := []string{}
+	optional := [][]string{
+		{fmt.Sprintf("%s.txt", mapName)},
+		{fmt.Sprintf("cfg-maps/%s.cfg", mapName)},
+		{fmt.Sprintf("maps/%s.map", mapName)},
+		{fmt.Sprintf("maps/%s.bsp", mapName)},
+		{fmt.Sprintf("scripts/%s.arena", mapName)},
+		{fmt.Sprintf("levelshots/%s.jpg", mapName), fmt.Sprintf("levelshots/%s.tga", mapName)},
+	}
+	for _, candidates := range optional {
+		resources, err = AddOptionalResource(files, resources, candidates...)
+		if err != nil {
+			return Contents{}, err
 		}
 	}
 
-	lightmaps := GetExternalLightmaps(basePath, mapName)
+	lightmaps, err := GetExternalLightmaps(files, mapName)
+	if err != nil {
+		return Contents{}, err
+	}
 
-	textures, sounds, shaderNames, shaderFiles := parser.ReadMap(mapName, basePath)
+	textures, sounds, models, shaderNames, shaderFiles, err := ReadDependencies(mapName, files, options)
+	if err != nil {
+		return Contents{}, err
+	}
 
+	stock := LoadStockIndex(basePath, files, options)
+
+	dependencies := []string{}
 	for texture := range maps.Keys(textures) {
-		resources = append(resources, texture)
+		dependencies = append(dependencies, texture)
 	}
 
 	for sound := range maps.Keys(sounds) {
-		resources = append(resources, sound)
+		dependencies = append(dependencies, sound)
 	}
 
-	for _, shaderFile := range shaderFiles {
-		resources = append(resources, "scripts/"+shaderFile)
+	for modelPath := range maps.Keys(models) {
+		dependencies = append(dependencies, modelPath)
 	}
 
+	generated := map[string]string{}
+	if options.TrimShaders {
+		content, err := TrimShaders(mapName, files, shaderNames, stock, options)
+		if err != nil {
+			return Contents{}, err
+		}
+		generated[fmt.Sprintf("scripts/%s.shader", mapName)] = content
+	} else {
+		for _, shaderFile := range shaderFiles {
+			dependencies = append(dependencies, "scripts/"+shaderFile)
+		}
+	}
+	resources = append(resources, FilterStockResources(options.Writer(), dependencies, stock)...)
 	resources = append(resources, lightmaps...)
-	resources = append(resources, shaderNames...)
 
-	pk3Path := CreatePk3(basePath, resources, mapName)
-	return pk3Path
+	resources, report, err := ApplyPatterns(files, resources, options.Include, options.Exclude)
+	if err != nil {
+		return Contents{}, err
+	}
+	for resourcePath := range generated {
+		pattern, excluded := MatchingPattern(resourcePath, options.Exclude)
+		if excluded {
+			delete(generated, resourcePath)
+			report.Excluded = append(report.Excluded, PatternMatch{resourcePath, pattern})
+			report.Unmatched = slices.DeleteFunc(report.Unmatched, func(unmatched string) bool {
+				return unmatched == pattern
+			})
+		}
+	}
+	SortPatternMatches(report.Excluded)
+
+	err = CheckResources(files, resources)
+	if err != nil {
+		return Contents{}, err
+	}
+
+	return Contents{
+		Resources: resources,
+		Generated: generated,
+		Archived:  FindArchivedResources(files, resources),
+		Patterns:  report,
+	}, nil
 }
 
-func CreatePk3(baseq3Folder string, resources []string, mapName string) string {
-	CreateDirectory("output")
-	for _, resource := range resources {
-		AddResourceIfExists(baseq3Folder, resource, "output")
+func PrintContents(output io.Writer, contents Contents) {
+	for _, resource := range contents.Resources {
+		fmt.Fprintf(output, "  %s\n", resource)
+	}
+	for _, resourcePath := range slices.Sorted(maps.Keys(contents.Generated)) {
+		fmt.Fprintf(output, "  %s (generated)\n", resourcePath)
 	}
+}
 
-	pk3Path, err := ZipOutputFolderAsPk3("output", mapName)
+// OutputPath is either a .pk3 file or a directory, Install writes into the
+// game directory instead so the engine picks the pk3 up straight away.
+func Pk3Path(mapName string, basePath string, options Options) (string, error) {
+	outputPath := options.OutputPath
+	if options.Install {
+		directories := GameDirectories(basePath, options.FsGame)
+		outputPath = directories[len(directories)-1]
+	}
+	if strings.EqualFold(filepath.Ext(outputPath), ".pk3") {
+		return outputPath, os.MkdirAll(filepath.Dir(outputPath), 0777)
+	}
+	if len(outputPath) == 0 {
+		outputPath = "."
+	}
+	err := os.MkdirAll(outputPath, 0777)
 	if err != nil {
-		fmt.Printf("Eyo? %s", err)
+		return "", err
+	}
+	pk3Name := mapName + ".pk3"
+	if len(options.Pk3Name) > 0 {
+		pk3Name = options.Pk3Name
 	}
-	return pk3Path
+	if !strings.EqualFold(filepath.Ext(pk3Name), ".pk3") {
+		pk3Name += ".pk3"
+	}
+	return filepath.Join(outputPath, pk3Name), nil
 }
 
-func CreateDirectory(folderName string) bool {
-	_, err := os.Stat(folderName)
+// Adds the first candidate that exists, a missing optional resource is not an error.
+func AddOptionalResource(files fs.FS, resources []string, candidates ...string) ([]string, error) {
+	for _, candidate := range candidates {
+		resource, err := GetFile(files, candidate)
+		if errors.Is(err, ErrMissingAsset) {
+			continue
+		}
+		if err != nil {
+			return resources, err
+		}
+		return append(resources, resource), nil
+	}
+	return resources, nil
+}
 
-	if err == nil {
-		fmt.Printf("Removing existing folder %s and contents\n", folderName)
-		DeleteFolderAndSubFolders(folderName)
+// The engine searches the mod directory before baseq3, so it is opened last
+// and its files win. The mod lives next to basePath under the same game root.
+func GameDirectories(basePath string, fsGame string) []string {
+	directories := []string{basePath}
+	gameRoot := filepath.Dir(filepath.Clean(basePath))
+	if len(fsGame) > 0 && fsGame != filepath.Base(filepath.Clean(basePath)) {
+		directories = append(directories, filepath.Join(gameRoot, fsGame))
 	}
+	return directories
+}
 
-	err = os.Mkdir(folderName, 0777)
-	if err != nil {
-		fmt.Printf("It blew up mate %s\n", err)
-		return false
+func FindArchivedResources(files *vfs.FS, resources []string) []ArchivedResource {
+	archived := []ArchivedResource{}
+	for _, resource := range resources {
+		source, ok := files.Source(resource)
+		if ok && source.Archive {
+			archived = append(archived, ArchivedResource{resource, source.Path})
+		}
 	}
+	slices.SortFunc(archived, func(a ArchivedResource, b ArchivedResource) int {
+		return strings.Compare(a.Resource, b.Resource)
+	})
+	return archived
+}
 
-	return true
+func PrintArchivedResources(output io.Writer, archived []ArchivedResource) {
+	if len(archived) == 0 {
+		return
+	}
+	fmt.Fprintf(output, "Extracting %d resources from other pk3s\n", len(archived))
+	for _, resource := range archived {
+		fmt.Fprintf(output, "  %s from %s\n", resource.Resource, resource.Archive)
+	}
 }
 
-func ZipOutputFolderAsPk3(outputFolder string, mapName string) (string, error) {
-	ex, err := os.Executable()
-	if err != nil {
-		return "", err
+func LoadStockIndex(basePath string, files *vfs.FS, options Options) pak.Index {
+	archives := options.StockArchives
+	if archives == nil {
+		archives = pak.DefaultArchives()
 	}
-	cwd := filepath.Dir(ex)
-	fmt.Printf("cwd %s\n", cwd)
-	pk3Path := material.AddTrailingSlash(cwd) + mapName + ".pk3"
-	file, err := os.Create(pk3Path)
+	return pak.Open(files, basePath, archives)
+}
+
+func FilterStockResources(output io.Writer, resources []string, stock pak.Index) []string {
+	custom := []string{}
+	for _, resource := range resources {
+		archive, isStock := stock.IsStock(resource)
+		if isStock {
+			fmt.Fprintf(output, "Skipping %s, it is already in %s\n", resource, archive)
+			continue
+		}
+		custom = append(custom, resource)
+	}
+	return custom
+}
+
+func TrimShaders(
+	mapName string,
+	files fs.FS,
+	shaderNames []string,
+	stock pak.Index,
+	options Options,
+) (string, error) {
+	shaderFiles, err := shader.LoadShaderFiles(
+		files,
+		"scripts",
+		shader.Options{UseShaderList: options.UseShaderList, Output: options.Writer()},
+	)
 	if err != nil {
-		fmt.Printf("Error occured while creating zip: %s", err)
 		return "", err
 	}
+	index := shader.IndexShaders(shaderFiles)
 
-	defer file.Close()
-
-	writer := zip.NewWriter(file)
-	defer writer.Close()
-
-	sourcePath := material.AddTrailingSlash(outputFolder)
-	err = filepath.WalkDir(
-		sourcePath,
-		func(path string, dir fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-
-			fileInfo, err := dir.Info()
-			if err != nil {
-				return err
-			}
-
-			if dir.Name() == mapName {
-				return nil
-			}
-
-			if dir.Name() == mapName+".pk3" {
-				return nil
-			}
-
-			header, err := zip.FileInfoHeader(fileInfo)
-			if err != nil {
-				return err
-			}
-
-			name := strings.Replace(path, sourcePath, "", 1)
-			header.Method = zip.Deflate
-			header.Name = name
-			if dir.IsDir() {
-				header.Name += "/"
-			}
-
-			headerWriter, err := writer.CreateHeader(header)
-			if err != nil {
-				return err
-			}
-
-			if dir.IsDir() {
-				return nil
-			}
-
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			_, err = io.Copy(headerWriter, file)
-			return err
-		},
+	shaders := []shader.Shader{}
+	for _, name := range shaderNames {
+		definition, ok := index[shader.ShaderKey(name)]
+		if ok && !stock.Contains("scripts/"+definition.File) {
+			shaders = append(shaders, definition)
+		}
+	}
+	return shader.TrimmedShaderFile(mapName, shaders), nil
+}
+
+func ReadDependencies(
+	mapName string,
+	files fs.FS,
+	options Options,
+) (map[string]int, map[string]int, map[string]int, []string, []string, error) {
+	source := options.Source
+	if source == SourceAuto {
+		source = SourceMap
+		_, err := GetFile(files, fmt.Sprintf("maps/%s.map", mapName))
+		if errors.Is(err, ErrMissingAsset) {
+			fmt.Fprintf(options.Writer(), "No .map source for %s, reading dependencies from the bsp\n", mapName)
+			source = SourceBsp
+		}
+	}
+
+	var materials, sounds, models map[string]int
+	var err error
+	if source == SourceBsp {
+		materials, sounds, models, err = parser.ReadBspMaterials(mapName, files)
+	} else {
+		materials, sounds, models, err = parser.ReadMapMaterials(mapName, files)
+	}
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, []string{}, []string{}, err
+	}
+	textures, sounds, shaderNames, shaderFiles, err := parser.ResolveDependencies(
+		materials,
+		sounds,
+		files,
+		shader.Options{UseShaderList: options.UseShaderList, Output: options.Writer()},
 	)
-	fmt.Printf("Created pk3 %s\n", pk3Path)
-	fmt.Printf("Deleting output folder")
-	DeleteFolderAndSubFolders(outputFolder)
-	return pk3Path, err
+	return textures, sounds, models, shaderNames, shaderFiles, err
 }
 
-func AddResourceIfExists(baseq3Folder string, resourcePath string, outputFolder string) string {
-	path := fmt.Sprintf("%s%s", material.AddTrailingSlash(baseq3Folder), resourcePath)
+func CreatePk3(files fs.FS, resources []string, pk3Path string) (string, error) {
+	return CreatePk3WithGeneratedFiles(files, resources, map[string]string{}, pk3Path)
+}
 
-	_, exists := os.Stat(path)
-	if exists != nil {
-		fmt.Printf("Resource does not exist: %s\n", path)
-		return ""
+func CreatePk3WithGeneratedFiles(
+	files fs.FS,
+	resources []string,
+	generated map[string]string,
+	pk3Path string,
+) (string, error) {
+	// The pk3 is written next to its destination and only renamed into place
+	// once complete, so a failed build keeps the previous pk3.
+	file, err := os.CreateTemp(filepath.Dir(pk3Path), "."+filepath.Base(pk3Path)+"-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("creating pk3: %w", err)
 	}
 
-	sourceFile, err := os.Open(path)
+	err = WritePk3(file, files, resources, generated)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), pk3Path)
+	}
 	if err != nil {
-		fmt.Printf("Something went wrong opening source file: %s\n", err)
-		return ""
+		os.Remove(file.Name())
+		return "", fmt.Errorf("writing %s: %w", pk3Path, err)
 	}
 
-	destPath := material.AddTrailingSlash(outputFolder) + resourcePath
-	destFolder := ExtractFolderPaths(destPath)
-	_, exists = os.Stat(destFolder)
-	if exists != nil {
-		err = os.MkdirAll(destFolder, 0777)
+	return pk3Path, nil
+}
+
+// Streams every resource straight from the filesystem into the archive, a
+// generated file replaces a resource with the same path.
+func WritePk3(output io.Writer, files fs.FS, resources []string, generated map[string]string) error {
+	writer := zip.NewWriter(output)
+
+	written := map[string]bool{}
+	for _, resource := range resources {
+		if _, ok := generated[resource]; ok || written[resource] {
+			continue
+		}
+		written[resource] = true
+		err := AddResource(writer, files, resource)
+		if err != nil {
+			writer.Close()
+			return err
+		}
+	}
+
+	for _, resourcePath := range slices.Sorted(maps.Keys(generated)) {
+		err := AddGeneratedResource(writer, resourcePath, generated[resourcePath])
 		if err != nil {
-			fmt.Printf("MkdirAll returned error: %s", err)
-			return ""
+			writer.Close()
+			return err
 		}
 	}
 
-	destFile, err := os.Create(destPath)
+	return writer.Close()
+}
+
+func AddResource(writer *zip.Writer, files fs.FS, resourcePath string) error {
+	sourceFile, err := files.Open(resourcePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrMissingAsset, resourcePath)
+	}
 	if err != nil {
-		fmt.Printf("Something went wrong creating target file: %s\n", err)
-		fmt.Printf("Resource path: %s\n", resourcePath)
-		return ""
+		return err
 	}
+	defer sourceFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
+	fileInfo, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(fileInfo)
 	if err != nil {
-		fmt.Printf("Something went wrong while copying: %s", err)
-		return ""
+		return err
 	}
+	header.Name = resourcePath
+	header.Method = zip.Deflate
 
-	fmt.Printf("Added resource %s\n", destPath)
-	return destPath
-}
+	headerWriter, err := writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(headerWriter, sourceFile)
+	if err != nil {
+		return fmt.Errorf("copying %s: %w", resourcePath, err)
+	}
 
-func DeleteFolderAndSubFolders(folder string) {
-	path := material.AddTrailingSlash(folder)
+	return nil
+}
 
-	_, err := os.Stat(path)
+func AddGeneratedResource(writer *zip.Writer, resourcePath string, content string) error {
+	header := &zip.FileHeader{Name: resourcePath, Method: zip.Deflate, Modified: time.Now()}
+	headerWriter, err := writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(headerWriter, content)
 	if err != nil {
-		fmt.Printf("Stat failed with error %s\n", err)
+		return fmt.Errorf("writing %s: %w", resourcePath, err)
 	}
 
-	fmt.Printf("Removing %s\n", path)
-	os.RemoveAll(path)
+	return nil
 }
 
-func GetFile(baseq3Folder string, filePathTemplate string) string {
-	filePath := fmt.Sprintf(
-		"%s%s",
-		material.AddTrailingSlash(baseq3Folder),
-		filePathTemplate,
-	)
-	_, err := os.Stat(filePath)
+func GetFile(files fs.FS, filePath string) (string, error) {
+	_, err := fs.Stat(files, filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrMissingAsset, filePath)
+	}
 	if err != nil {
-		fmt.Println(err)
-		return ""
+		return "", err
 	}
 
-	return filePathTemplate
+	return filePath, nil
+}
+
+// Every resource is looked up before anything is written, so check fails on
+// the same missing assets as a build.
+func CheckResources(files fs.FS, resources []string) error {
+	for _, resource := range resources {
+		_, err := GetFile(files, resource)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func GetExternalLightmaps(baseq3Folder string, mapName string) []string {
-	mapFilePath := fmt.Sprintf("%smaps/%s/", material.AddTrailingSlash(baseq3Folder), mapName)
-	file, err := os.Open(mapFilePath)
+func GetExternalLightmaps(files fs.FS, mapName string) ([]string, error) {
+	lightmapFolder := fmt.Sprintf("maps/%s", mapName)
 	lightmaps := []string{}
+	_, err := fs.Stat(files, lightmapFolder)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lightmaps, nil
+	}
 	if err != nil {
-		return lightmaps
+		return lightmaps, err
 	}
 
-	err = filepath.WalkDir(mapFilePath, func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(files, lightmapFolder, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if mapName == d.Name() {
+		if path == lightmapFolder {
 			return err
 		}
 
@@ -273,66 +560,22 @@ func GetExternalLightmaps(baseq3Folder string, mapName string) []string {
 		return err
 	})
 	if err != nil {
-		fmt.Printf("an error occured while walking %s\n", err)
-		return lightmaps
+		return lightmaps, fmt.Errorf("reading lightmaps: %w", err)
 	}
 
-	defer file.Close()
-	return lightmaps
+	return lightmaps, nil
 }
 
-func GetArenaFile(baseq3Folder string, mapName string) string {
-	arenaFilePath := fmt.Sprintf(
-		"%sscripts/%s.arena",
-		material.AddTrailingSlash(baseq3Folder),
-		mapName,
-	)
-	file, err := os.Open(arenaFilePath)
-	if err != nil {
-		fmt.Println(err)
-		return ""
-	}
-
-	defer file.Close()
-	fmt.Printf("Found arena file %s.arena\n", mapName)
-	return fmt.Sprintf("scripts/%s.arena", mapName)
+func GetArenaFile(files fs.FS, mapName string) (string, error) {
+	return GetFile(files, fmt.Sprintf("scripts/%s.arena", mapName))
 }
 
-func GetLevelshot(baseq3Folder string, mapName string) string {
-	levelshotsPath := fmt.Sprintf(
-		"%slevelshots/%s",
-		material.AddTrailingSlash(baseq3Folder),
-		mapName,
-	)
-	jpg := fmt.Sprintf("%s.jpg", levelshotsPath)
-	tga := fmt.Sprintf("%s.tga", levelshotsPath)
-	jpgFile, jpgErr := os.Open(jpg)
-
-	if jpgErr == nil {
-		return fmt.Sprintf("levelshots/%s.jpg", mapName)
-	}
-	defer jpgFile.Close()
-
-	tgaFile, tgaErr := os.Open(tga)
-
-	if tgaErr == nil {
-		return fmt.Sprintf("levelshots/%s.tga", mapName)
-	}
-
-	defer tgaFile.Close()
-
-	return ""
-}
-
-func ExtractFolderPaths(fullPath string) string {
-	if strings.Contains(fullPath, ".") {
-		split := strings.Split(fullPath, "/")
-		if len(split) > 0 {
-			split = split[:len(split)-1]
-			fullPath = strings.Join(split, "/")
+func GetLevelshot(files fs.FS, mapName string) (string, error) {
+	for _, extension := range []string{"jpg", "tga"} {
+		levelshot, err := GetFile(files, fmt.Sprintf("levelshots/%s.%s", mapName, extension))
+		if !errors.Is(err, ErrMissingAsset) {
+			return levelshot, err
 		}
-		return fullPath
-	} else {
-		return fullPath
 	}
+	return "", fmt.Errorf("%w: levelshots/%s", ErrMissingAsset, mapName)
 }
@@ -0,0 +1,91 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"gomaker/internal/config"
+)
+
+// Reads the config passed in ConfigPath, or the one found next to the map,
+// and merges it into options.
+func LoadOptions(mapName string, basePath string, options Options) (Options, error) {
+	configPath := options.ConfigPath
+	if len(configPath) == 0 {
+		found, ok := config.Find(GameDirectories(basePath, options.FsGame), mapName)
+		if !ok {
+			return options, nil
+		}
+		configPath = found
+	}
+
+	mapConfig, err := config.Read(configPath)
+	if err != nil {
+		return options, err
+	}
+	if len(mapConfig.Map) > 0 && mapConfig.Map != mapName {
+		return options, fmt.Errorf(
+			"%w: %s is for map %s, not %s",
+			config.ErrInvalidConfig,
+			configPath,
+			mapConfig.Map,
+			mapName,
+		)
+	}
+	if len(mapConfig.OutputPath) > 0 && !filepath.IsAbs(mapConfig.OutputPath) {
+		mapConfig.OutputPath = filepath.Join(filepath.Dir(configPath), mapConfig.OutputPath)
+	}
+	fmt.Fprintf(options.Writer(), "Using config %s\n", configPath)
+	return ApplyConfig(options, mapConfig)
+}
+
+// Values set on options win over the config, patterns from both are kept.
+func ApplyConfig(options Options, mapConfig config.Config) (Options, error) {
+	if len(options.FsGame) == 0 {
+		options.FsGame = mapConfig.FsGame
+	}
+	if len(options.Pk3Name) == 0 {
+		options.Pk3Name = mapConfig.Pk3Name
+	}
+	if len(options.OutputPath) == 0 {
+		options.OutputPath = mapConfig.OutputPath
+	}
+	if options.Source == SourceAuto && len(mapConfig.Source) > 0 {
+		source, err := ParseSource(mapConfig.Source)
+		if err != nil {
+			return options, fmt.Errorf("%w: %w", config.ErrInvalidConfig, err)
+		}
+		options.Source = source
+	}
+
+	options.Include = append(append([]string{}, mapConfig.Include...), options.Include...)
+	options.Exclude = append(append([]string{}, mapConfig.Exclude...), options.Exclude...)
+	options.TrimShaders = options.TrimShaders || mapConfig.TrimShaders
+	options.UseShaderList = options.UseShaderList || mapConfig.UseShaderList
+	options.Verify = options.Verify || mapConfig.Verify
+	options.FailOnMismatch = options.FailOnMismatch || mapConfig.FailOnMismatch
+	options.Install = options.Install || mapConfig.Install
+	return options, nil
+}
+
+func ParseSource(source string) (Source, error) {
+	switch source {
+	case "", "auto":
+		return SourceAuto, nil
+	case "map":
+		return SourceMap, nil
+	case "bsp":
+		return SourceBsp, nil
+	}
+	return SourceAuto, fmt.Errorf("unknown source %s, expected auto, map or bsp", source)
+}
+
+func (source Source) String() string {
+	switch source {
+	case SourceMap:
+		return "map"
+	case SourceBsp:
+		return "bsp"
+	}
+	return "auto"
+}
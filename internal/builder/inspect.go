@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"archive/zip"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Pk3Entry struct {
+	Name     string
+	Size     uint64
+	Modified time.Time
+}
+
+type Pk3Diff struct {
+	Missing []string
+	Extra   []string
+}
+
+func (diff Pk3Diff) HasDifference() bool {
+	return len(diff.Missing) > 0 || len(diff.Extra) > 0
+}
+
+func InspectPk3(pk3Path string) ([]Pk3Entry, error) {
+	reader, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", pk3Path, err)
+	}
+	defer reader.Close()
+
+	entries := []Pk3Entry{}
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		entries = append(entries, Pk3Entry{file.Name, file.UncompressedSize64, file.Modified})
+	}
+	slices.SortFunc(entries, func(a Pk3Entry, b Pk3Entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries, nil
+}
+
+// Compares what a build would pack against an existing pk3, paths are
+// compared case-insensitively like the engine does.
+func DiffPk3(contents Contents, entries []Pk3Entry) Pk3Diff {
+	expected := map[string]string{}
+	for _, resource := range contents.Resources {
+		expected[strings.ToLower(resource)] = resource
+	}
+	for resourcePath := range maps.Keys(contents.Generated) {
+		expected[strings.ToLower(resourcePath)] = resourcePath
+	}
+
+	diff := Pk3Diff{Missing: []string{}, Extra: []string{}}
+	packed := map[string]bool{}
+	for _, entry := range entries {
+		key := strings.ToLower(entry.Name)
+		packed[key] = true
+		if _, ok := expected[key]; !ok {
+			diff.Extra = append(diff.Extra, entry.Name)
+		}
+	}
+	for key, resource := range expected {
+		if !packed[key] {
+			diff.Missing = append(diff.Missing, resource)
+		}
+	}
+	slices.Sort(diff.Missing)
+	slices.Sort(diff.Extra)
+	return diff
+}
@@ -0,0 +1,200 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+type PatternMatch struct {
+	Resource string
+	Pattern  string
+}
+
+type PatternReport struct {
+	Included  []PatternMatch
+	Excluded  []PatternMatch
+	Unmatched []string
+}
+
+func NewPatternReport() PatternReport {
+	return PatternReport{Included: []PatternMatch{}, Excluded: []PatternMatch{}, Unmatched: []string{}}
+}
+
+// Include patterns add every matching file, exclude patterns are applied last
+// so they win over both includes and collected dependencies. The report names
+// the first rule responsible for each file that was added or left out.
+func ApplyPatterns(
+	files fs.FS,
+	resources []string,
+	include []string,
+	exclude []string,
+) ([]string, PatternReport, error) {
+	report := NewPatternReport()
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		err := ValidatePattern(pattern)
+		if err != nil {
+			return resources, report, err
+		}
+	}
+
+	collected := map[string]bool{}
+	for _, resource := range resources {
+		collected[resource] = true
+	}
+
+	candidates := slices.Clone(resources)
+	used := map[string]bool{}
+	for _, pattern := range include {
+		matches, err := GlobFiles(files, pattern)
+		if err != nil {
+			return resources, report, fmt.Errorf("include %s: %w", pattern, err)
+		}
+		for _, match := range matches {
+			used[pattern] = true
+			if collected[match] {
+				continue
+			}
+			collected[match] = true
+			candidates = append(candidates, match)
+			report.Included = append(report.Included, PatternMatch{match, pattern})
+		}
+	}
+
+	filtered := []string{}
+	seen := map[string]bool{}
+	for _, resource := range candidates {
+		if seen[resource] {
+			continue
+		}
+		seen[resource] = true
+
+		pattern, excluded := MatchingPattern(resource, exclude)
+		if excluded {
+			used[pattern] = true
+			report.Excluded = append(report.Excluded, PatternMatch{resource, pattern})
+			continue
+		}
+		filtered = append(filtered, resource)
+	}
+
+	report.Included = slices.DeleteFunc(report.Included, func(match PatternMatch) bool {
+		return !slices.Contains(filtered, match.Resource)
+	})
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if !used[pattern] && !slices.Contains(report.Unmatched, pattern) {
+			report.Unmatched = append(report.Unmatched, pattern)
+		}
+	}
+	slices.Sort(filtered)
+	SortPatternMatches(report.Included)
+	SortPatternMatches(report.Excluded)
+	return filtered, report, nil
+}
+
+// Walks only below the literal prefix of the pattern, a pattern matching a
+// directory includes everything beneath it.
+func GlobFiles(files fs.FS, pattern string) ([]string, error) {
+	root := "."
+	segments := strings.Split(pattern, "/")
+	for index, segment := range segments[:len(segments)-1] {
+		if strings.ContainsAny(segment, "*?[\\") {
+			break
+		}
+		root = strings.Join(segments[:index+1], "/")
+	}
+
+	matches := []string{}
+	err := fs.WalkDir(files, root, func(filePath string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && MatchesPath(pattern, filePath) {
+			matches = append(matches, filePath)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+func MatchingPattern(resource string, patterns []string) (string, bool) {
+	for _, pattern := range patterns {
+		if MatchesPath(pattern, resource) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// A pattern matches a file or any of its parent directories, so "env/mysky"
+// and "env/mysky/**" are the same rule.
+func MatchesPath(pattern string, resource string) bool {
+	for candidate := resource; candidate != "." && candidate != "/"; candidate = path.Dir(candidate) {
+		if MatchPattern(pattern, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// Like path.Match, with "**" matching any number of directories.
+func MatchPattern(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for index := 0; index <= len(name); index++ {
+			if matchSegments(pattern[1:], name[index:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], name[0])
+	return err == nil && matched && matchSegments(pattern[1:], name[1:])
+}
+
+func ValidatePattern(pattern string) error {
+	if len(pattern) == 0 {
+		return fmt.Errorf("%w: empty pattern", path.ErrBadPattern)
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		_, err := path.Match(segment, "")
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, pattern)
+		}
+	}
+	return nil
+}
+
+func SortPatternMatches(matches []PatternMatch) {
+	slices.SortFunc(matches, func(a PatternMatch, b PatternMatch) int {
+		return strings.Compare(a.Resource, b.Resource)
+	})
+}
+
+func PrintPatternReport(output io.Writer, report PatternReport) {
+	for _, match := range report.Included {
+		fmt.Fprintf(output, "Included %s by rule %s\n", match.Resource, match.Pattern)
+	}
+	for _, match := range report.Excluded {
+		fmt.Fprintf(output, "Excluded %s by rule %s\n", match.Resource, match.Pattern)
+	}
+	for _, pattern := range report.Unmatched {
+		fmt.Fprintf(output, "Rule %s matched no files\n", pattern)
+	}
+}
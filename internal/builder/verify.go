@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+
+	"gomaker/internal/bsp"
+	"gomaker/internal/parser"
+	"gomaker/internal/vfs"
+)
+
+type ShaderMismatch struct {
+	OnlyInMap []string
+	OnlyInBsp []string
+}
+
+type CheckReport struct {
+	Compared bool
+	Mismatch ShaderMismatch
+	Contents Contents
+}
+
+func (mismatch ShaderMismatch) HasMismatch() bool {
+	return len(mismatch.OnlyInMap) > 0 || len(mismatch.OnlyInBsp) > 0
+}
+
+// Resolves every dependency so missing assets surface as errors, and compares
+// map and bsp shaders when both are present.
+func Check(mapName string, basePath string, options Options) (CheckReport, error) {
+	options, err := LoadOptions(mapName, basePath, options)
+	if err != nil {
+		return CheckReport{}, err
+	}
+
+	directories := GameDirectories(basePath, options.FsGame)
+	files, err := vfs.Open(directories...)
+	if err != nil {
+		return CheckReport{}, fmt.Errorf("opening game directories: %w", err)
+	}
+	defer files.Close()
+
+	contents, err := ReadContents(mapName, basePath, files, options)
+	if err != nil {
+		return CheckReport{}, err
+	}
+
+	report := CheckReport{
+		Mismatch: ShaderMismatch{OnlyInMap: []string{}, OnlyInBsp: []string{}},
+		Contents: contents,
+	}
+	_, mapErr := fs.Stat(files, "maps/"+mapName+".map")
+	_, bspErr := fs.Stat(files, "maps/"+mapName+".bsp")
+	if mapErr != nil || bspErr != nil {
+		return report, nil
+	}
+
+	report.Mismatch, err = VerifyBsp(mapName, files)
+	if err != nil {
+		return report, fmt.Errorf("comparing %s.map and %s.bsp: %w", mapName, mapName, err)
+	}
+	report.Compared = true
+	return report, nil
+}
+
+func VerifyBsp(mapName string, files fs.FS) (ShaderMismatch, error) {
+	mapFile, err := parser.ParseFS(files, "maps/"+mapName+".map")
+	if err != nil {
+		return ShaderMismatch{}, err
+	}
+
+	bspFile, err := bsp.ReadFS(files, "maps/"+mapName+".bsp")
+	if err != nil {
+		return ShaderMismatch{}, err
+	}
+
+	return CompareMaterials(parser.GetGeometryMaterials(mapFile), parser.GetBspMaterials(bspFile)), nil
+}
+
+func CompareMaterials(mapMaterials map[string]int, bspMaterials map[string]int) ShaderMismatch {
+	mismatch := ShaderMismatch{OnlyInMap: []string{}, OnlyInBsp: []string{}}
+	for name := range mapMaterials {
+		if _, ok := bspMaterials[name]; !ok {
+			mismatch.OnlyInMap = append(mismatch.OnlyInMap, name)
+		}
+	}
+	for name := range bspMaterials {
+		if _, ok := mapMaterials[name]; !ok {
+			mismatch.OnlyInBsp = append(mismatch.OnlyInBsp, name)
+		}
+	}
+	slices.Sort(mismatch.OnlyInMap)
+	slices.Sort(mismatch.OnlyInBsp)
+	return mismatch
+}
+
+func PrintShaderMismatch(output io.Writer, mapName string, mismatch ShaderMismatch) {
+	if !mismatch.HasMismatch() {
+		fmt.Fprintf(output, "Shaders in %s.map and %s.bsp match\n", mapName, mapName)
+		return
+	}
+	fmt.Fprintf(output, "Shaders in %s.map and %s.bsp differ, is the bsp up to date?\n", mapName, mapName)
+	for _, name := range mismatch.OnlyInMap {
+		fmt.Fprintf(output, "  only in .map: %s\n", name)
+	}
+	for _, name := range mismatch.OnlyInBsp {
+		fmt.Fprintf(output, "  only in .bsp: %s\n", name)
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Field names match case-insensitively, so a config can be written with
+// lowercase keys such as "include" or "fsGame". Map and BasePath are only
+// read by the CLI, a relative BasePath or OutputPath is resolved against the
+// config file.
+type Config struct {
+	Map            string
+	BasePath       string
+	FsGame         string
+	Pk3Name        string
+	OutputPath     string
+	Source         string
+	Include        []string
+	Exclude        []string
+	TrimShaders    bool
+	UseShaderList  bool
+	Verify         bool
+	FailOnMismatch bool
+	Install        bool
+}
+
+func FileName(mapName string) string {
+	return mapName + ".gomaker.json"
+}
+
+func Read(configPath string) (Config, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return Config{}, err
+	}
+	defer file.Close()
+
+	config := Config{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("%w: %s: %w", ErrInvalidConfig, configPath, err)
+	}
+	return config, nil
+}
+
+// Looks for <map>.gomaker.json next to the map source, a later directory wins
+// the same way its files do.
+func Find(directories []string, mapName string) (string, bool) {
+	for index := len(directories) - 1; index >= 0; index-- {
+		configPath := filepath.Join(directories[index], "maps", FileName(mapName))
+		info, err := os.Stat(configPath)
+		if err == nil && !info.IsDir() {
+			return configPath, true
+		}
+	}
+	return "", false
+}
@@ -1,116 +1,215 @@
 package entity
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
-	"os"
-	"regexp"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 
 	"gomaker/internal/material"
+	"gomaker/internal/model"
 )
 
-func IsEntity(line string) bool {
-	match, err := regexp.MatchString("// entity", strings.ToLower(line))
-	if err != nil {
-		fmt.Println("Something went wrong", err)
+func ParseKeyValues(keyValues map[string]string, files fs.FS) (map[string]int, error) {
+	textures := map[string]int{}
+	for key, value := range keyValues {
+		if strings.HasPrefix(key, "_remap") {
+			_, remapped, _ := strings.Cut(value, ";")
+			texture := RemapTexture(remapped)
+			if len(texture) > 0 {
+				textures[texture] = textures[texture] + 1
+			}
+		}
+	}
+	if len(textures) > 0 {
+		return textures, nil
+	}
+
+	modelPath := keyValues["model"]
+	if len(modelPath) > 0 && IsBakedModel(keyValues) {
+		return ParseModel(modelPath, files)
 	}
 
-	return match
+	for _, modelPath := range RuntimeModels(keyValues) {
+		modelTextures, err := ParseModel(modelPath, files)
+		if err != nil {
+			return textures, err
+		}
+		skins, err := ModelSkins(modelPath, keyValues, files)
+		if err != nil {
+			return textures, err
+		}
+		for _, skinPath := range skins {
+			skinTextures, err := ParseSkin(skinPath, files)
+			if err != nil {
+				return textures, err
+			}
+			MergeTextures(skinTextures, modelTextures)
+		}
+		MergeTextures(modelTextures, textures)
+	}
+	return textures, nil
 }
 
-func ParseEntity(lines []string) map[string]int {
-	textures := map[string]int{}
-	modelPathLine := ""
-	isModel := false
-	for _, line := range lines {
-		if !isModel {
-			// Only overwrite variable if we haven't determined if it's a model yet
-			isModel = strings.Contains(line, "misc_model")
-		}
-		if strings.Contains(line, "_remap") {
-			texture := RemapTexture(line)
-			textures[texture] = textures[texture] + 1
-			return textures
-		} else if strings.Contains(line, ".ase") {
-			modelPathLine = line
-		} else if strings.Contains(line, ".obj") {
-			modelPathLine = strings.Replace(line, ".obj", ".mtl", 1)
+// Model files the engine loads at runtime and that therefore have to ship.
+// misc_model geometry is baked into the bsp and brush models such as "*1"
+// live in the bsp as well.
+func RuntimeModels(keyValues map[string]string) []string {
+	modelFiles := []string{}
+	for _, key := range []string{"model", "model2"} {
+		modelPath := NormalizeModelPath(keyValues[key])
+		if len(modelPath) == 0 || strings.HasPrefix(modelPath, "*") || len(path.Ext(modelPath)) == 0 {
+			continue
+		}
+		if key == "model" && IsBakedModel(keyValues) {
+			continue
+		}
+		if !slices.Contains(modelFiles, modelPath) {
+			modelFiles = append(modelFiles, modelPath)
 		}
 	}
-	if isModel {
-		modelPath := ModelPath(modelPathLine)
-		textures = ParseModel(modelPath)
+	return modelFiles
+}
+
+// q3map2 always bakes a misc_model, its spawnflags only control clipping and
+// lighting, and the game frees the entity so the model is never loaded.
+func IsBakedModel(keyValues map[string]string) bool {
+	return keyValues["classname"] == "misc_model"
+}
+
+// Files that are loaded together with a model: the material libraries of an
+// .obj, the default skins of the model, skins the entity names and LOD
+// variants such as lamp_1.md3.
+func ModelCompanions(modelPath string, keyValues map[string]string, files fs.FS) ([]string, error) {
+	companions := []string{}
+	extension := path.Ext(modelPath)
+	base := strings.TrimSuffix(modelPath, extension)
+	if strings.EqualFold(extension, ".obj") {
+		obj, err := model.ReadOBJFS(files, modelPath)
+		if err != nil {
+			return companions, err
+		}
+		companions = append(companions, obj.LibraryPaths(modelPath)...)
+	}
+
+	candidates := []string{base + ".skin", base + "_default.skin"}
+	candidates = append(candidates, NamedSkins(modelPath, keyValues)...)
+	candidates = append(candidates, base+"_1"+extension, base+"_2"+extension)
+	for _, candidate := range candidates {
+		found, err := FindFile(files, candidate)
+		if err != nil {
+			return companions, err
+		}
+		if len(found) > 0 && !slices.Contains(companions, found) {
+			companions = append(companions, found)
+		}
 	}
-	return textures
+	return companions, nil
 }
 
-func ModelPath(line string) string {
-	_, after, didCut := strings.Cut(line, "model")
-	if didCut {
-		after = strings.Replace(after, `"`, "", 3)
-		return strings.TrimSpace(after)
+// Skins picked with the "skin" or "_skin" key, either by number as in
+// lamp_2.skin or by path.
+func NamedSkins(modelPath string, keyValues map[string]string) []string {
+	skins := []string{}
+	base := strings.TrimSuffix(modelPath, path.Ext(modelPath))
+	for _, key := range []string{"_skin", "skin"} {
+		value := NormalizeModelPath(keyValues[key])
+		number, err := strconv.Atoi(value)
+		if err == nil && number > 0 {
+			skins = append(skins, fmt.Sprintf("%s_%d.skin", base, number))
+		} else if strings.EqualFold(path.Ext(value), ".skin") {
+			skins = append(skins, value)
+		}
 	}
-	return ""
+	return skins
 }
 
-func ParseModel(modelPath string) map[string]int {
-	textures := map[string]int{}
-	file, err := os.Open(modelPath)
+// Looks a file up by name ignoring case like the engine does, an empty path
+// means it does not exist.
+func FindFile(files fs.FS, filePath string) (string, error) {
+	entries, err := fs.ReadDir(files, path.Dir(filePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
 	if err != nil {
-		fmt.Printf("Failed opening file %v with path %s, error %s", file, modelPath, err)
+		return "", err
 	}
-	defer file.Close()
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(entry.Name(), path.Base(filePath)) {
+			return path.Join(path.Dir(filePath), entry.Name()), nil
+		}
+	}
+	return "", nil
+}
 
-	scanner := bufio.NewScanner(file)
-	texture := ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(modelPath, ".mtl") {
-			texture = ObjTexture(line)
-		} else {
-			texture = AseTexture(line)
+func ModelSkins(modelPath string, keyValues map[string]string, files fs.FS) ([]string, error) {
+	companions, err := ModelCompanions(modelPath, keyValues, files)
+	skins := slices.DeleteFunc(companions, func(companion string) bool {
+		return !strings.EqualFold(path.Ext(companion), ".skin")
+	})
+	return skins, err
+}
+
+// Skin lines pair a surface with a shader, tag lines have no shader.
+func ParseSkin(skinPath string, files fs.FS) (map[string]int, error) {
+	textures := map[string]int{}
+	data, err := fs.ReadFile(files, skinPath)
+	if err != nil {
+		return textures, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		_, shaderName, found := strings.Cut(strings.TrimSpace(line), ",")
+		if !found {
+			continue
 		}
+		texture := material.GetMaterial(strings.ReplaceAll(strings.Trim(shaderName, `"`), "\\", "/"))
 		if len(texture) > 0 {
 			textures[texture] = textures[texture] + 1
 		}
-
 	}
+	return textures, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println(err)
+func MergeTextures(source map[string]int, destination map[string]int) {
+	for texture, count := range source {
+		destination[texture] = destination[texture] + count
 	}
-	return textures
 }
 
-func ObjTexture(line string) string {
-	materialRegex := regexp.MustCompile("map_Kd")
-	mat := materialRegex.FindString(line)
-	if len(mat) > 0 {
-		line = strings.TrimSpace(line)
-		_, after, didCut := strings.Cut(line, `textures`)
-		if didCut {
-			texture := strings.ReplaceAll(after, "\\", "/")
-			texture = strings.Replace(texture, `"`, "", 1)
-			return material.GetMaterial(texture)
+func NormalizeModelPath(modelPath string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(strings.TrimSpace(modelPath), "\\", "/"), "/")
+}
+
+// Material libraries count as models here so a .mtl can be read on its own.
+func ParseModel(modelPath string, files fs.FS) (map[string]int, error) {
+	textures := map[string]int{}
+	modelPath = NormalizeModelPath(modelPath)
+	if !model.IsSupported(modelPath) && !strings.EqualFold(path.Ext(modelPath), ".mtl") {
+		_, err := fs.Stat(files, modelPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return textures, fmt.Errorf("%w: model %s", material.ErrMissingAsset, modelPath)
 		}
+		return textures, err
 	}
-	return ""
-}
 
-func AseTexture(line string) string {
-	materialRegex := regexp.MustCompile(`\*BITMAP[^_]`)
-	mat := materialRegex.FindString(line)
-	if len(mat) > 0 {
-		line = strings.TrimSpace(line)
-		_, after, didCut := strings.Cut(line, `textures`)
-		if didCut {
-			texture := strings.ReplaceAll(after, "\\", "/")
-			texture = strings.Replace(texture, `"`, "", 1)
-			return material.GetMaterial(texture)
+	shaderNames, err := model.ShaderNames(files, modelPath)
+	pathError := &fs.PathError{}
+	if errors.As(err, &pathError) && errors.Is(err, fs.ErrNotExist) {
+		return textures, fmt.Errorf("%w: model %s", material.ErrMissingAsset, pathError.Path)
+	}
+	if err != nil {
+		return textures, err
+	}
+	for _, shaderName := range shaderNames {
+		texture := material.GetMaterial(shaderName)
+		if len(texture) > 0 {
+			textures[texture] = textures[texture] + 1
 		}
 	}
-	return ""
+	return textures, nil
 }
 
 func RemapTexture(line string) string {
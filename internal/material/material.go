@@ -1,12 +1,16 @@
 package material
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"path"
 	"regexp"
 	"strings"
 )
 
+var ErrMissingAsset = errors.New("missing asset")
+
 type Materials struct {
 	Textures map[string]int
 	Shaders  map[string]int
@@ -16,49 +20,37 @@ func GetMaterial(line string) string {
 	textureRegex := regexp.MustCompile(`((\w+[\/_-]*)+\/((\w)+[\/_-]*)*)+`)
 	texture := textureRegex.FindString(line)
 	if len(texture) > 0 {
-		if IsCustomMaterial(texture) {
-			return FormatPath(texture)
-		}
+		return FormatPath(texture)
 	}
 	return ""
 }
 
-func IsCustomMaterial(texture string) bool {
-	common := [5]string{"common/", "common_alphascale/", "sfx/", "liquids/", "effects/"}
-	for _, value := range common {
-		if strings.Contains(texture, value) {
-			return false
-		}
-	}
-	return true
-}
-
 func FormatPath(texture string) string {
 	return strings.Replace(texture, "textures/", "", 1)
 }
 
-func IsTexture(material string, baseFolderPath string) (bool, string) {
-	fsPath := AddTrailingSlash(baseFolderPath) + "textures/" + material
-	jpg := fmt.Sprintf("%s.jpg", fsPath)
-	tga := fmt.Sprintf("%s.tga", fsPath)
-	jpgFile, jpgErr := os.Open(jpg)
-
-	if jpgErr == nil {
-		return true, fmt.Sprintf("textures/%s.jpg", material)
+func IsTexture(material string, files fs.FS) (bool, string) {
+	if IsVideo(material) {
+		_, err := fs.Stat(files, material)
+		return err == nil, material
 	}
-
-	defer jpgFile.Close()
-
-	tgaFile, tgaErr := os.Open(tga)
-
-	if tgaErr == nil {
-		return true, fmt.Sprintf("textures/%s.tga", material)
+	for _, folder := range []string{"textures/", ""} {
+		for _, extension := range []string{"jpg", "tga"} {
+			filePath := fmt.Sprintf("%s%s.%s", folder, material, extension)
+			_, err := fs.Stat(files, filePath)
+			if err == nil {
+				return true, filePath
+			}
+		}
 	}
-
-	defer tgaFile.Close()
 	return false, material
 }
 
+// Videos are named with their extension and never fall back to jpg or tga.
+func IsVideo(material string) bool {
+	return strings.EqualFold(path.Ext(material), ".roq")
+}
+
 func AddTrailingSlash(path string) string {
 	if path == "" {
 		return path
@@ -73,10 +65,10 @@ func AddTrailingSlash(path string) string {
 	}
 }
 
-func SortMaterials(materials map[string]int, basePath string) Materials {
+func SortMaterials(materials map[string]int, files fs.FS) Materials {
 	sorted := Materials{make(map[string]int), make(map[string]int)}
 	for material := range materials {
-		isT, filePath := IsTexture(material, basePath)
+		isT, filePath := IsTexture(material, files)
 		if isT {
 			sorted.Textures[filePath] = sorted.Textures[filePath] + 1
 			// It can also be a shader
@@ -88,10 +80,10 @@ func SortMaterials(materials map[string]int, basePath string) Materials {
 	return sorted
 }
 
-func AddTexturePathWithExtension(textures map[string]int, basePath string) map[string]int {
+func AddTexturePathWithExtension(textures map[string]int, files fs.FS) map[string]int {
 	returnValue := map[string]int{}
 	for material := range textures {
-		isT, filePath := IsTexture(material, basePath)
+		isT, filePath := IsTexture(material, files)
 		if isT {
 			returnValue[filePath] = returnValue[filePath] + 1
 		}
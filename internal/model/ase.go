@@ -0,0 +1,242 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type AseMaterial struct {
+	Name         string
+	Class        string
+	Bitmap       string
+	SubMaterials []AseMaterial
+}
+
+type AseObject struct {
+	Name        string
+	MaterialRef int
+}
+
+type ASE struct {
+	Materials []AseMaterial
+	Objects   []AseObject
+}
+
+type aseToken struct {
+	Text   string
+	Quoted bool
+}
+
+type aseNode struct {
+	Key      string
+	Values   []string
+	Children []*aseNode
+}
+
+func ReadASEFile(path string) (*ASE, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ase, err := ReadASE(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ase, nil
+}
+
+func ReadASEFS(files fs.FS, name string) (*ASE, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ase, err := ReadASE(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return ase, nil
+}
+
+// Exporters don't always balance their braces, blocks still open at the end
+// of the file are closed there.
+func ReadASE(reader io.Reader) (*ASE, error) {
+	tokens, err := aseTokens(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &aseNode{}
+	stack := []*aseNode{root}
+	var current *aseNode
+	for _, token := range tokens {
+		parent := stack[len(stack)-1]
+		switch {
+		case token.Quoted:
+			if current != nil {
+				current.Values = append(current.Values, token.Text)
+			}
+		case token.Text == "{":
+			if current == nil {
+				return nil, fmt.Errorf("%w: block without a key", ErrInvalidModel)
+			}
+			stack = append(stack, current)
+			current = nil
+		case token.Text == "}":
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("%w: unexpected }", ErrInvalidModel)
+			}
+			stack = stack[:len(stack)-1]
+			current = nil
+		case strings.HasPrefix(token.Text, "*"):
+			current = &aseNode{Key: strings.ToUpper(token.Text)}
+			parent.Children = append(parent.Children, current)
+		case current != nil:
+			current.Values = append(current.Values, token.Text)
+		}
+	}
+
+	ase := &ASE{Materials: []AseMaterial{}, Objects: []AseObject{}}
+	for _, list := range root.findAll("*MATERIAL_LIST") {
+		for _, node := range list.children("*MATERIAL") {
+			ase.Materials = append(ase.Materials, readAseMaterial(node))
+		}
+	}
+	for _, node := range root.findAll("*GEOMOBJECT") {
+		materialRef, err := strconv.Atoi(node.value("*MATERIAL_REF"))
+		if err != nil {
+			materialRef = -1
+		}
+		ase.Objects = append(ase.Objects, AseObject{Name: node.value("*NODE_NAME"), MaterialRef: materialRef})
+	}
+	return ase, nil
+}
+
+// Shader names of every material in the list, a multi material contributes its
+// submaterials instead of itself.
+func (ase *ASE) ShaderNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, material := range ase.Materials {
+		for _, name := range material.ShaderNames() {
+			if len(name) > 0 && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func (material AseMaterial) ShaderNames() []string {
+	if len(material.SubMaterials) == 0 {
+		return []string{material.ShaderName()}
+	}
+	names := []string{}
+	for _, subMaterial := range material.SubMaterials {
+		names = append(names, subMaterial.ShaderNames()...)
+	}
+	return names
+}
+
+// Like q3map2 the diffuse bitmap names the shader, the material name is used
+// when there is no bitmap.
+func (material AseMaterial) ShaderName() string {
+	name := material.Bitmap
+	if len(name) == 0 || strings.EqualFold(name, "none") {
+		name = material.Name
+	}
+	return ShaderPath(name)
+}
+
+func readAseMaterial(node *aseNode) AseMaterial {
+	material := AseMaterial{
+		Name:         node.value("*MATERIAL_NAME"),
+		Class:        node.value("*MATERIAL_CLASS"),
+		SubMaterials: []AseMaterial{},
+	}
+	for _, diffuse := range node.children("*MAP_DIFFUSE") {
+		bitmap := diffuse.value("*BITMAP")
+		if len(bitmap) > 0 {
+			material.Bitmap = bitmap
+		}
+	}
+	for _, subMaterial := range node.children("*SUBMATERIAL") {
+		material.SubMaterials = append(material.SubMaterials, readAseMaterial(subMaterial))
+	}
+	return material
+}
+
+func (node *aseNode) children(key string) []*aseNode {
+	children := []*aseNode{}
+	for _, child := range node.Children {
+		if child.Key == key {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (node *aseNode) findAll(key string) []*aseNode {
+	found := []*aseNode{}
+	for _, child := range node.Children {
+		if child.Key == key {
+			found = append(found, child)
+			continue
+		}
+		found = append(found, child.findAll(key)...)
+	}
+	return found
+}
+
+// The last value of a direct child, so "*MATERIAL 0" style keys give the
+// part after the index.
+func (node *aseNode) value(key string) string {
+	for _, child := range node.Children {
+		if child.Key == key && len(child.Values) > 0 {
+			return child.Values[len(child.Values)-1]
+		}
+	}
+	return ""
+}
+
+func aseTokens(reader io.Reader) ([]aseToken, error) {
+	tokens := []aseToken{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		for len(line) > 0 {
+			line = strings.TrimLeft(line, " \t\r")
+			if len(line) == 0 {
+				break
+			}
+			if line[0] == '"' {
+				end := strings.IndexByte(line[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("%w: unterminated string on line %d", ErrInvalidModel, lineNumber)
+				}
+				tokens = append(tokens, aseToken{Text: line[1 : end+1], Quoted: true})
+				line = line[end+2:]
+				continue
+			}
+			end := strings.IndexAny(line, " \t\r")
+			if end < 0 {
+				end = len(line)
+			}
+			tokens = append(tokens, aseToken{Text: line[:end]})
+			line = line[end:]
+		}
+	}
+	return tokens, scanner.Err()
+}
@@ -0,0 +1,209 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	iqmMagic   = "INTERQUAKEMODEL\x00"
+	iqmVersion = 2
+
+	iqmHeaderSize = 124
+	iqmMeshSize   = 24
+)
+
+type IQMMesh struct {
+	Name          string
+	Material      string
+	FirstVertex   uint32
+	NumVertexes   uint32
+	FirstTriangle uint32
+	NumTriangles  uint32
+}
+
+type IQM struct {
+	Version   uint32
+	Flags     uint32
+	NumJoints uint32
+	NumAnims  uint32
+	NumFrames uint32
+	Meshes    []IQMMesh
+}
+
+type iqmHeader struct {
+	Magic            [16]byte
+	Version          uint32
+	FileSize         uint32
+	Flags            uint32
+	NumText          uint32
+	OfsText          uint32
+	NumMeshes        uint32
+	OfsMeshes        uint32
+	NumVertexArrays  uint32
+	NumVertexes      uint32
+	OfsVertexArrays  uint32
+	NumTriangles     uint32
+	OfsTriangles     uint32
+	OfsAdjacency     uint32
+	NumJoints        uint32
+	OfsJoints        uint32
+	NumPoses         uint32
+	OfsPoses         uint32
+	NumAnims         uint32
+	OfsAnims         uint32
+	NumFrames        uint32
+	NumFrameChannels uint32
+	OfsFrames        uint32
+	OfsBounds        uint32
+	NumComment       uint32
+	OfsComment       uint32
+	NumExtensions    uint32
+	OfsExtensions    uint32
+}
+
+type iqmMesh struct {
+	Name          uint32
+	Material      uint32
+	FirstVertex   uint32
+	NumVertexes   uint32
+	FirstTriangle uint32
+	NumTriangles  uint32
+}
+
+func ReadIQMFile(path string) (*IQM, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	iqm, err := ReadIQM(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return iqm, nil
+}
+
+func ReadIQMFS(files fs.FS, name string) (*IQM, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	iqm, err := ReadIQM(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return iqm, nil
+}
+
+// Only the text and mesh sections are read, the names of meshes and their
+// materials are offsets into the text section.
+func ReadIQM(reader io.Reader) (*IQM, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < iqmHeaderSize {
+		return nil, fmt.Errorf("%w: file too short for an iqm header (%d bytes)", ErrInvalidModel, len(data))
+	}
+
+	header := iqmHeader{}
+	err = binary.Read(bytes.NewReader(data[:iqmHeaderSize]), binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != iqmMagic {
+		return nil, fmt.Errorf("%w: unsupported iqm magic %q", ErrInvalidModel, cString(header.Magic[:]))
+	}
+	if header.Version != iqmVersion {
+		return nil, fmt.Errorf(
+			"%w: unsupported iqm version %d, expected %d",
+			ErrInvalidModel,
+			header.Version,
+			iqmVersion,
+		)
+	}
+	if uint64(header.FileSize) > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: iqm file size %d exceeds %d bytes", ErrInvalidModel, header.FileSize, len(data))
+	}
+
+	text, err := iqmSection(data, "text", header.OfsText, header.NumText, 1)
+	if err != nil {
+		return nil, err
+	}
+	records, err := iqmSection(data, "meshes", header.OfsMeshes, header.NumMeshes, iqmMeshSize)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]iqmMesh, header.NumMeshes)
+	err = binary.Read(bytes.NewReader(records), binary.LittleEndian, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	iqm := &IQM{
+		Version:   header.Version,
+		Flags:     header.Flags,
+		NumJoints: header.NumJoints,
+		NumAnims:  header.NumAnims,
+		NumFrames: header.NumFrames,
+		Meshes:    make([]IQMMesh, 0, len(raw)),
+	}
+	for index, mesh := range raw {
+		name, err := iqmText(text, mesh.Name)
+		if err != nil {
+			return nil, fmt.Errorf("mesh %d name: %w", index, err)
+		}
+		material, err := iqmText(text, mesh.Material)
+		if err != nil {
+			return nil, fmt.Errorf("mesh %d material: %w", index, err)
+		}
+		iqm.Meshes = append(iqm.Meshes, IQMMesh{
+			Name:          name,
+			Material:      material,
+			FirstVertex:   mesh.FirstVertex,
+			NumVertexes:   mesh.NumVertexes,
+			FirstTriangle: mesh.FirstTriangle,
+			NumTriangles:  mesh.NumTriangles,
+		})
+	}
+	return iqm, nil
+}
+
+// The engine loads each mesh material as a shader.
+func (iqm *IQM) ShaderNames() []string {
+	names := []string{}
+	for _, mesh := range iqm.Meshes {
+		names = append(names, mesh.Material)
+	}
+	return uniqueNames(names)
+}
+
+func iqmSection(data []byte, name string, offset uint32, count uint32, size int) ([]byte, error) {
+	start := uint64(offset)
+	end := start + uint64(count)*uint64(size)
+	if count > 0 && end > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: %s out of bounds (offset %d, count %d)", ErrInvalidModel, name, offset, count)
+	}
+	if count == 0 {
+		return []byte{}, nil
+	}
+	return data[start:end], nil
+}
+
+func iqmText(text []byte, offset uint32) (string, error) {
+	if uint64(offset) >= uint64(len(text)) {
+		if offset == 0 {
+			return "", nil
+		}
+		return "", fmt.Errorf("%w: text offset %d out of bounds (%d bytes)", ErrInvalidModel, offset, len(text))
+	}
+	return cString(text[offset:]), nil
+}
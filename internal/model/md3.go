@@ -0,0 +1,310 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	md3Ident   = "IDP3"
+	md3Version = 15
+
+	md3NameLength      = 64
+	md3FrameNameLength = 16
+	md3HeaderSize      = 108
+	md3FrameSize       = 56
+	md3TagSize         = 112
+	md3SurfaceSize     = 108
+	md3ShaderSize      = md3NameLength + 4
+)
+
+type Frame struct {
+	MinBounds   [3]float32
+	MaxBounds   [3]float32
+	LocalOrigin [3]float32
+	Radius      float32
+	Name        string
+}
+
+type Tag struct {
+	Name   string
+	Origin [3]float32
+	Axis   [3][3]float32
+}
+
+type Surface struct {
+	Name         string
+	Flags        int32
+	NumFrames    int32
+	NumVerts     int32
+	NumTriangles int32
+	Shaders      []string
+}
+
+// Tags holds NumTags entries for every frame, frame by frame.
+type MD3 struct {
+	Ident    string
+	Version  int32
+	Name     string
+	Flags    int32
+	NumTags  int32
+	Frames   []Frame
+	Tags     []Tag
+	Surfaces []Surface
+}
+
+type md3Header struct {
+	Flags       int32
+	NumFrames   int32
+	NumTags     int32
+	NumSurfaces int32
+	NumSkins    int32
+	OfsFrames   int32
+	OfsTags     int32
+	OfsSurfaces int32
+	OfsEnd      int32
+}
+
+type md3Frame struct {
+	MinBounds   [3]float32
+	MaxBounds   [3]float32
+	LocalOrigin [3]float32
+	Radius      float32
+	Name        [md3FrameNameLength]byte
+}
+
+type md3Tag struct {
+	Name   [md3NameLength]byte
+	Origin [3]float32
+	Axis   [3][3]float32
+}
+
+type md3Surface struct {
+	Ident         [4]byte
+	Name          [md3NameLength]byte
+	Flags         int32
+	NumFrames     int32
+	NumShaders    int32
+	NumVerts      int32
+	NumTriangles  int32
+	OfsTriangles  int32
+	OfsShaders    int32
+	OfsSt         int32
+	OfsXyzNormals int32
+	OfsEnd        int32
+}
+
+func ReadMD3File(path string) (*MD3, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	md3, err := ReadMD3(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return md3, nil
+}
+
+func ReadMD3FS(files fs.FS, name string) (*MD3, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	md3, err := ReadMD3(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return md3, nil
+}
+
+func ReadMD3(reader io.Reader) (*MD3, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < md3HeaderSize {
+		return nil, fmt.Errorf("%w: file too short for an md3 header (%d bytes)", ErrInvalidModel, len(data))
+	}
+
+	md3 := &MD3{
+		Ident:   string(data[:4]),
+		Version: int32(binary.LittleEndian.Uint32(data[4:8])),
+		Name:    cString(data[8 : 8+md3NameLength]),
+	}
+	if md3.Ident != md3Ident {
+		return nil, fmt.Errorf("%w: unsupported md3 ident %q", ErrInvalidModel, md3.Ident)
+	}
+	if md3.Version != md3Version {
+		return nil, fmt.Errorf(
+			"%w: unsupported md3 version %d, expected %d",
+			ErrInvalidModel,
+			md3.Version,
+			md3Version,
+		)
+	}
+
+	header := md3Header{}
+	err = binary.Read(bytes.NewReader(data[8+md3NameLength:md3HeaderSize]), binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	md3.Flags = header.Flags
+	md3.NumTags = header.NumTags
+
+	md3.Frames, err = readFrames(data, header)
+	if err != nil {
+		return nil, err
+	}
+	md3.Tags, err = readTags(data, header)
+	if err != nil {
+		return nil, err
+	}
+	md3.Surfaces, err = readSurfaces(data, header)
+	if err != nil {
+		return nil, err
+	}
+	return md3, nil
+}
+
+// Shader names in surface order without duplicates, surfaces that are skinned
+// through a .skin file have no shader and are skipped.
+func (md3 *MD3) ShaderNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, surface := range md3.Surfaces {
+		for _, name := range surface.Shaders {
+			if len(name) > 0 && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func readFrames(data []byte, header md3Header) ([]Frame, error) {
+	records, err := section(data, "frames", header.OfsFrames, header.NumFrames, md3FrameSize)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]md3Frame, header.NumFrames)
+	err = binary.Read(bytes.NewReader(records), binary.LittleEndian, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]Frame, 0, len(raw))
+	for _, frame := range raw {
+		frames = append(frames, Frame{
+			MinBounds:   frame.MinBounds,
+			MaxBounds:   frame.MaxBounds,
+			LocalOrigin: frame.LocalOrigin,
+			Radius:      frame.Radius,
+			Name:        cString(frame.Name[:]),
+		})
+	}
+	return frames, nil
+}
+
+func readTags(data []byte, header md3Header) ([]Tag, error) {
+	if header.NumTags < 0 || header.NumFrames < 0 {
+		return nil, fmt.Errorf("%w: negative tag count %d", ErrInvalidModel, header.NumTags)
+	}
+	count := int64(header.NumTags) * int64(header.NumFrames)
+	if count*md3TagSize > int64(len(data)) {
+		return nil, fmt.Errorf(
+			"%w: %d tags in %d frames exceed %d bytes",
+			ErrInvalidModel,
+			header.NumTags,
+			header.NumFrames,
+			len(data),
+		)
+	}
+	records, err := section(data, "tags", header.OfsTags, int32(count), md3TagSize)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]md3Tag, count)
+	err = binary.Read(bytes.NewReader(records), binary.LittleEndian, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]Tag, 0, len(raw))
+	for _, tag := range raw {
+		tags = append(tags, Tag{Name: cString(tag.Name[:]), Origin: tag.Origin, Axis: tag.Axis})
+	}
+	return tags, nil
+}
+
+func readSurfaces(data []byte, header md3Header) ([]Surface, error) {
+	if header.NumSurfaces < 0 {
+		return nil, fmt.Errorf("%w: negative surface count %d", ErrInvalidModel, header.NumSurfaces)
+	}
+	if int64(header.NumSurfaces)*md3SurfaceSize > int64(len(data)) {
+		return nil, fmt.Errorf("%w: %d surfaces exceed %d bytes", ErrInvalidModel, header.NumSurfaces, len(data))
+	}
+
+	surfaces := make([]Surface, 0, header.NumSurfaces)
+	offset := int64(header.OfsSurfaces)
+	for index := 0; index < int(header.NumSurfaces); index++ {
+		if offset < 0 || offset+md3SurfaceSize > int64(len(data)) {
+			return nil, fmt.Errorf("%w: surface %d out of bounds (offset %d)", ErrInvalidModel, index, offset)
+		}
+		raw := md3Surface{}
+		err := binary.Read(bytes.NewReader(data[offset:offset+md3SurfaceSize]), binary.LittleEndian, &raw)
+		if err != nil {
+			return nil, err
+		}
+		if string(raw.Ident[:]) != md3Ident {
+			return nil, fmt.Errorf("%w: surface %d has ident %q", ErrInvalidModel, index, raw.Ident[:])
+		}
+
+		surface := Surface{
+			Name:         cString(raw.Name[:]),
+			Flags:        raw.Flags,
+			NumFrames:    raw.NumFrames,
+			NumVerts:     raw.NumVerts,
+			NumTriangles: raw.NumTriangles,
+			Shaders:      []string{},
+		}
+		surfaceData := data[offset:]
+		shaders, err := section(surfaceData, "shaders", raw.OfsShaders, raw.NumShaders, md3ShaderSize)
+		if err != nil {
+			return nil, fmt.Errorf("surface %d: %w", index, err)
+		}
+		for shaderOffset := 0; shaderOffset < len(shaders); shaderOffset += md3ShaderSize {
+			surface.Shaders = append(surface.Shaders, cString(shaders[shaderOffset:shaderOffset+md3NameLength]))
+		}
+		surfaces = append(surfaces, surface)
+
+		if raw.OfsEnd <= 0 {
+			return nil, fmt.Errorf("%w: surface %d has end offset %d", ErrInvalidModel, index, raw.OfsEnd)
+		}
+		offset += int64(raw.OfsEnd)
+	}
+	return surfaces, nil
+}
+
+func section(data []byte, name string, offset int32, count int32, size int) ([]byte, error) {
+	start := int64(offset)
+	end := start + int64(count)*int64(size)
+	if offset < 0 || count < 0 || end > int64(len(data)) {
+		return nil, fmt.Errorf("%w: %s out of bounds (offset %d, count %d)", ErrInvalidModel, name, offset, count)
+	}
+	return data[start:end], nil
+}
+
+func cString(data []byte) string {
+	name, _, _ := bytes.Cut(data, []byte{0})
+	return string(name)
+}
@@ -0,0 +1,224 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	mdrIdent   = "RDM5"
+	mdrVersion = 2
+
+	mdrHeaderSize  = 104
+	mdrLODSize     = 12
+	mdrSurfaceSize = 168
+)
+
+type MDRSurface struct {
+	Name         string
+	Shader       string
+	NumVerts     int32
+	NumTriangles int32
+}
+
+type MDRLOD struct {
+	Surfaces []MDRSurface
+}
+
+type MDR struct {
+	Ident     string
+	Version   int32
+	Name      string
+	NumFrames int32
+	NumBones  int32
+	NumTags   int32
+	LODs      []MDRLOD
+}
+
+type mdrHeader struct {
+	NumFrames int32
+	NumBones  int32
+	OfsFrames int32
+	NumLODs   int32
+	OfsLODs   int32
+	NumTags   int32
+	OfsTags   int32
+	OfsEnd    int32
+}
+
+type mdrLOD struct {
+	NumSurfaces int32
+	OfsSurfaces int32
+	OfsEnd      int32
+}
+
+type mdrSurface struct {
+	Ident             int32
+	Name              [md3NameLength]byte
+	Shader            [md3NameLength]byte
+	ShaderIndex       int32
+	OfsHeader         int32
+	NumVerts          int32
+	OfsVerts          int32
+	NumTriangles      int32
+	OfsTriangles      int32
+	NumBoneReferences int32
+	OfsBoneReferences int32
+	OfsEnd            int32
+}
+
+func ReadMDRFile(path string) (*MDR, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mdr, err := ReadMDR(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return mdr, nil
+}
+
+func ReadMDRFS(files fs.FS, name string) (*MDR, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mdr, err := ReadMDR(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return mdr, nil
+}
+
+// Frames and bones are skipped, only the surfaces of each LOD are read for
+// their shaders.
+func ReadMDR(reader io.Reader) (*MDR, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < mdrHeaderSize {
+		return nil, fmt.Errorf("%w: file too short for an mdr header (%d bytes)", ErrInvalidModel, len(data))
+	}
+
+	mdr := &MDR{
+		Ident:   string(data[:4]),
+		Version: int32(binary.LittleEndian.Uint32(data[4:8])),
+		Name:    cString(data[8 : 8+md3NameLength]),
+	}
+	if mdr.Ident != mdrIdent {
+		return nil, fmt.Errorf("%w: unsupported mdr ident %q", ErrInvalidModel, mdr.Ident)
+	}
+	if mdr.Version != mdrVersion {
+		return nil, fmt.Errorf(
+			"%w: unsupported mdr version %d, expected %d",
+			ErrInvalidModel,
+			mdr.Version,
+			mdrVersion,
+		)
+	}
+
+	header := mdrHeader{}
+	err = binary.Read(bytes.NewReader(data[8+md3NameLength:mdrHeaderSize]), binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	mdr.NumFrames = header.NumFrames
+	mdr.NumBones = header.NumBones
+	mdr.NumTags = header.NumTags
+
+	mdr.LODs, err = readLODs(data, header)
+	if err != nil {
+		return nil, err
+	}
+	return mdr, nil
+}
+
+// Shader names of the surfaces of every LOD without duplicates.
+func (mdr *MDR) ShaderNames() []string {
+	names := []string{}
+	for _, lod := range mdr.LODs {
+		for _, surface := range lod.Surfaces {
+			names = append(names, surface.Shader)
+		}
+	}
+	return uniqueNames(names)
+}
+
+// LODs follow each other, surfaces are stored inside their LOD and every
+// offset is relative to the start of the record it belongs to.
+func readLODs(data []byte, header mdrHeader) ([]MDRLOD, error) {
+	if header.NumLODs < 0 {
+		return nil, fmt.Errorf("%w: negative lod count %d", ErrInvalidModel, header.NumLODs)
+	}
+	if int64(header.NumLODs)*mdrLODSize > int64(len(data)) {
+		return nil, fmt.Errorf("%w: %d lods exceed %d bytes", ErrInvalidModel, header.NumLODs, len(data))
+	}
+
+	lods := make([]MDRLOD, 0, header.NumLODs)
+	offset := int64(header.OfsLODs)
+	for index := 0; index < int(header.NumLODs); index++ {
+		if offset < 0 || offset+mdrLODSize > int64(len(data)) {
+			return nil, fmt.Errorf("%w: lod %d out of bounds (offset %d)", ErrInvalidModel, index, offset)
+		}
+		raw := mdrLOD{}
+		err := binary.Read(bytes.NewReader(data[offset:offset+mdrLODSize]), binary.LittleEndian, &raw)
+		if err != nil {
+			return nil, err
+		}
+
+		surfaces, err := readMDRSurfaces(data, offset+int64(raw.OfsSurfaces), raw.NumSurfaces)
+		if err != nil {
+			return nil, fmt.Errorf("lod %d: %w", index, err)
+		}
+		lods = append(lods, MDRLOD{Surfaces: surfaces})
+
+		if raw.OfsEnd <= 0 {
+			return nil, fmt.Errorf("%w: lod %d has end offset %d", ErrInvalidModel, index, raw.OfsEnd)
+		}
+		offset += int64(raw.OfsEnd)
+	}
+	return lods, nil
+}
+
+func readMDRSurfaces(data []byte, offset int64, count int32) ([]MDRSurface, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("%w: negative surface count %d", ErrInvalidModel, count)
+	}
+	if int64(count)*mdrSurfaceSize > int64(len(data)) {
+		return nil, fmt.Errorf("%w: %d surfaces exceed %d bytes", ErrInvalidModel, count, len(data))
+	}
+
+	surfaces := make([]MDRSurface, 0, count)
+	for index := 0; index < int(count); index++ {
+		if offset < 0 || offset+mdrSurfaceSize > int64(len(data)) {
+			return nil, fmt.Errorf("%w: surface %d out of bounds (offset %d)", ErrInvalidModel, index, offset)
+		}
+		raw := mdrSurface{}
+		err := binary.Read(bytes.NewReader(data[offset:offset+mdrSurfaceSize]), binary.LittleEndian, &raw)
+		if err != nil {
+			return nil, err
+		}
+		surfaces = append(surfaces, MDRSurface{
+			Name:         cString(raw.Name[:]),
+			Shader:       cString(raw.Shader[:]),
+			NumVerts:     raw.NumVerts,
+			NumTriangles: raw.NumTriangles,
+		})
+
+		if raw.OfsEnd <= 0 {
+			return nil, fmt.Errorf("%w: surface %d has end offset %d", ErrInvalidModel, index, raw.OfsEnd)
+		}
+		offset += int64(raw.OfsEnd)
+	}
+	return surfaces, nil
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Directories a shader path starts from, an absolute or relative texture path
+// is cut down to the first of them it contains.
+var shaderRoots = []string{"textures", "models", "gfx", "sprites", "env"}
+
+var (
+	ErrInvalidModel     = errors.New("invalid model")
+	ErrUnsupportedModel = errors.New("unsupported model format")
+)
+
+// Returns the shader names a model's surfaces reference, in the order the
+// model lists them.
+func ShaderNames(files fs.FS, modelPath string) ([]string, error) {
+	switch strings.ToLower(path.Ext(modelPath)) {
+	case ".md3":
+		md3, err := ReadMD3FS(files, modelPath)
+		if err != nil {
+			return nil, err
+		}
+		return md3.ShaderNames(), nil
+	case ".ase":
+		ase, err := ReadASEFS(files, modelPath)
+		if err != nil {
+			return nil, err
+		}
+		return ase.ShaderNames(), nil
+	case ".iqm":
+		iqm, err := ReadIQMFS(files, modelPath)
+		if err != nil {
+			return nil, err
+		}
+		return iqm.ShaderNames(), nil
+	case ".mdr":
+		mdr, err := ReadMDRFS(files, modelPath)
+		if err != nil {
+			return nil, err
+		}
+		return mdr.ShaderNames(), nil
+	case ".obj":
+		return ObjShaderNames(files, modelPath)
+	case ".mtl":
+		mtl, err := ReadMTLFS(files, modelPath)
+		if err != nil {
+			return nil, err
+		}
+		return mtl.ShaderNames(path.Dir(modelPath), []string{}), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedModel, modelPath)
+}
+
+func IsSupported(modelPath string) bool {
+	switch strings.ToLower(path.Ext(modelPath)) {
+	case ".md3", ".iqm", ".mdr", ".ase", ".obj":
+		return true
+	}
+	return false
+}
+
+// Converts a texture path written by a modelling tool into a game path without
+// extension, "C:\\quake3\\baseq3\\textures\\a\\b.tga" becomes "textures/a/b".
+func ShaderPath(name string) string {
+	shaderPath, _ := RootedShaderPath(name)
+	return shaderPath
+}
+
+// Reports whether the path contained one of the game's root directories, a
+// path without one is returned with leading "../" and drive letters removed.
+func RootedShaderPath(name string) (string, bool) {
+	name = strings.ReplaceAll(strings.TrimSpace(name), "\\", "/")
+	segments := strings.Split(name, "/")
+	for index, segment := range segments[:len(segments)-1] {
+		for _, root := range shaderRoots {
+			if strings.EqualFold(segment, root) {
+				name = strings.Join(segments[index:], "/")
+				return strings.TrimSuffix(name, path.Ext(name)), true
+			}
+		}
+	}
+	for _, prefix := range []string{"./", "../", "/"} {
+		for strings.HasPrefix(name, prefix) {
+			name = strings.TrimPrefix(name, prefix)
+		}
+	}
+	if len(name) > 1 && name[1] == ':' {
+		name = strings.TrimPrefix(name[2:], "/")
+	}
+	return strings.TrimSuffix(name, path.Ext(name)), false
+}
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type OBJ struct {
+	MaterialLibraries []string
+	Materials         []string
+}
+
+type MTLMaterial struct {
+	Name    string
+	Diffuse string
+	Ambient string
+	Alpha   string
+	Bump    string
+}
+
+type MTL struct {
+	Materials []MTLMaterial
+}
+
+func ReadOBJFS(files fs.FS, name string) (*OBJ, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	obj, err := ReadOBJ(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return obj, nil
+}
+
+// Only the material statements are read, geometry is skipped.
+func ReadOBJ(reader io.Reader) (*OBJ, error) {
+	obj := &OBJ{MaterialLibraries: []string{}, Materials: []string{}}
+	err := scanStatements(reader, func(keyword string, arguments string) {
+		switch keyword {
+		case "mtllib":
+			for _, library := range strings.Fields(arguments) {
+				library = strings.ReplaceAll(library, "\\", "/")
+				if !containsFold(obj.MaterialLibraries, library) {
+					obj.MaterialLibraries = append(obj.MaterialLibraries, library)
+				}
+			}
+		case "usemtl":
+			if len(arguments) > 0 && !containsFold(obj.Materials, arguments) {
+				obj.Materials = append(obj.Materials, arguments)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Material libraries are named relative to the .obj file.
+func (obj *OBJ) LibraryPaths(objPath string) []string {
+	libraryPaths := []string{}
+	for _, library := range obj.MaterialLibraries {
+		libraryPaths = append(libraryPaths, path.Join(path.Dir(objPath), library))
+	}
+	return libraryPaths
+}
+
+func ReadMTLFS(files fs.FS, name string) (*MTL, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mtl, err := ReadMTL(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return mtl, nil
+}
+
+func ReadMTL(reader io.Reader) (*MTL, error) {
+	mtl := &MTL{Materials: []MTLMaterial{}}
+	var current *MTLMaterial
+	err := scanStatements(reader, func(keyword string, arguments string) {
+		if keyword == "newmtl" {
+			mtl.Materials = append(mtl.Materials, MTLMaterial{Name: arguments})
+			current = &mtl.Materials[len(mtl.Materials)-1]
+			return
+		}
+		if current == nil {
+			return
+		}
+		switch keyword {
+		case "map_kd":
+			current.Diffuse = mapFileName(arguments)
+		case "map_ka":
+			current.Ambient = mapFileName(arguments)
+		case "map_d":
+			current.Alpha = mapFileName(arguments)
+		case "bump", "map_bump":
+			current.Bump = mapFileName(arguments)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mtl, nil
+}
+
+// q3map2 uses the usemtl name as the shader, the texture maps of the material
+// are returned after it so plain images referenced by the model ship as well.
+// Every material is used when materials is empty.
+func (mtl *MTL) ShaderNames(directory string, materials []string) []string {
+	names := []string{}
+	for _, material := range mtl.Materials {
+		if len(materials) > 0 && !containsFold(materials, material.Name) {
+			continue
+		}
+		names = append(names, material.Name)
+		for _, texture := range material.Textures() {
+			names = append(names, MaterialTexturePath(directory, texture))
+		}
+	}
+	return names
+}
+
+func (material MTLMaterial) Textures() []string {
+	textures := []string{}
+	for _, texture := range []string{material.Diffuse, material.Ambient, material.Alpha, material.Bump} {
+		if len(texture) > 0 {
+			textures = append(textures, texture)
+		}
+	}
+	return textures
+}
+
+// Texture paths without a game directory in them are relative to the .mtl.
+func MaterialTexturePath(directory string, texture string) string {
+	shaderPath, rooted := RootedShaderPath(texture)
+	texture = strings.ReplaceAll(strings.TrimSpace(texture), "\\", "/")
+	if rooted || path.IsAbs(texture) || (len(texture) > 1 && texture[1] == ':') {
+		return shaderPath
+	}
+	texture = path.Join(directory, texture)
+	return strings.TrimSuffix(texture, path.Ext(texture))
+}
+
+// Shader names for the materials an .obj uses, taken from all of its material
+// libraries. A usemtl name that no library defines is still returned.
+func ObjShaderNames(files fs.FS, objPath string) ([]string, error) {
+	obj, err := ReadOBJFS(files, objPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	defined := []string{}
+	for _, libraryPath := range obj.LibraryPaths(objPath) {
+		mtl, err := ReadMTLFS(files, libraryPath)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, mtl.ShaderNames(path.Dir(libraryPath), obj.Materials)...)
+		for _, material := range mtl.Materials {
+			defined = append(defined, material.Name)
+		}
+	}
+	for _, material := range obj.Materials {
+		if !containsFold(defined, material) {
+			names = append(names, material)
+		}
+	}
+	return uniqueNames(names), nil
+}
+
+// Texture map options such as "-s 1 1 1" or "-bm 0.5" come before the file
+// name, which may contain spaces.
+func mapFileName(arguments string) string {
+	fields := strings.Fields(arguments)
+	index := 0
+	for index < len(fields) && strings.HasPrefix(fields[index], "-") {
+		option := strings.ToLower(fields[index])
+		index++
+		for index < len(fields)-1 && isOptionValue(option, fields[index]) {
+			index++
+		}
+	}
+	return strings.Join(fields[index:], " ")
+}
+
+func isOptionValue(option string, value string) bool {
+	_, err := strconv.ParseFloat(value, 64)
+	switch {
+	case err == nil:
+		return true
+	case option == "-blendu" || option == "-blendv" || option == "-clamp" || option == "-cc":
+		return value == "on" || value == "off"
+	case option == "-imfchan":
+		return len(value) == 1 && strings.Contains("rgbmlz", value)
+	}
+	return false
+}
+
+func scanStatements(reader io.Reader, handle func(keyword string, arguments string)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			arguments := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+			handle(strings.ToLower(fields[0]), arguments)
+		}
+	}
+	return scanner.Err()
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueNames(names []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if len(name) > 0 && !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
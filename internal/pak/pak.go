@@ -0,0 +1,79 @@
+package pak
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gomaker/internal/vfs"
+)
+
+type Index struct {
+	Archives []string
+	Files    map[string]string
+}
+
+func DefaultArchives() []string {
+	archives := []string{}
+	for number := 0; number <= 8; number++ {
+		archives = append(archives, fmt.Sprintf("pak%d.pk3", number))
+	}
+	return archives
+}
+
+// Indexes the stock archives in basePath from the archives the vfs already
+// opened, archives that are missing are skipped.
+func Open(files *vfs.FS, basePath string, archives []string) Index {
+	index := Index{[]string{}, map[string]string{}}
+	for _, archive := range archives {
+		for _, archivePath := range files.Archives {
+			if filepath.Dir(archivePath) != filepath.Clean(basePath) ||
+				!strings.EqualFold(filepath.Base(archivePath), archive) {
+				continue
+			}
+			names, _ := files.ArchiveFiles(archivePath)
+			for _, name := range names {
+				index.Files[NormalizePath(name)] = filepath.Base(archivePath)
+			}
+			index.Archives = append(index.Archives, filepath.Base(archivePath))
+		}
+	}
+	return index
+}
+
+func NormalizePath(resource string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.ToSlash(resource), "/"))
+}
+
+func (index Index) Archive(resource string) (string, bool) {
+	archive, ok := index.Files[NormalizePath(resource)]
+	return archive, ok
+}
+
+func (index Index) Contains(resource string) bool {
+	_, ok := index.Archive(resource)
+	return ok
+}
+
+// The engine falls back between .tga and .jpg when loading an image, so a
+// stock image with either extension shadows the loose one.
+func (index Index) IsStock(resource string) (string, bool) {
+	candidates := []string{resource}
+	extension := path.Ext(resource)
+	base := strings.TrimSuffix(resource, extension)
+	switch strings.ToLower(extension) {
+	case ".jpg":
+		candidates = append(candidates, base+".tga")
+	case ".tga":
+		candidates = append(candidates, base+".jpg")
+	}
+
+	for _, candidate := range candidates {
+		archive, ok := index.Archive(candidate)
+		if ok {
+			return archive, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,86 @@
+package parser
+
+type Map struct {
+	Version  int
+	Entities []*Entity
+}
+
+type Entity struct {
+	Pos        Position
+	Properties []Property
+	Brushes    []*Brush
+	Patches    []*Patch
+}
+
+type Property struct {
+	Pos   Position
+	Key   string
+	Value string
+}
+
+type BrushFormat int
+
+const (
+	BrushClassic BrushFormat = iota
+	BrushPrimitives
+	BrushDef3
+)
+
+type Brush struct {
+	Pos    Position
+	Format BrushFormat
+	Faces  []*BrushFace
+}
+
+type BrushFace struct {
+	Pos           Position
+	Plane         [3][3]float64
+	PlaneEquation [4]float64
+	TexMatrix     [2][3]float64
+	Texture       string
+	Shift         [2]float64
+	Rotation      float64
+	Scale         [2]float64
+	ContentFlags  int
+	SurfaceFlags  int
+	Value         int
+}
+
+type Patch struct {
+	Pos           Position
+	Type          string
+	Texture       string
+	Width         int
+	Height        int
+	Subdivisions  [2]int
+	ContentFlags  int
+	SurfaceFlags  int
+	Value         int
+	ControlPoints [][]PatchVertex
+}
+
+type PatchVertex struct {
+	XYZ [3]float64
+	UV  [2]float64
+}
+
+func (entity *Entity) ValueForKey(key string) string {
+	for _, property := range entity.Properties {
+		if property.Key == key {
+			return property.Value
+		}
+	}
+	return ""
+}
+
+func (entity *Entity) Classname() string {
+	return entity.ValueForKey("classname")
+}
+
+func (entity *Entity) KeyValues() map[string]string {
+	keyValues := map[string]string{}
+	for _, property := range entity.Properties {
+		keyValues[property.Key] = property.Value
+	}
+	return keyValues
+}
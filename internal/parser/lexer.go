@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+)
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenLBrace
+	TokenRBrace
+	TokenLParen
+	TokenRParen
+	TokenString
+	TokenWord
+)
+
+func (kind TokenKind) String() string {
+	switch kind {
+	case TokenEOF:
+		return "end of file"
+	case TokenLBrace:
+		return "'{'"
+	case TokenRBrace:
+		return "'}'"
+	case TokenLParen:
+		return "'('"
+	case TokenRParen:
+		return "')'"
+	case TokenString:
+		return "string"
+	case TokenWord:
+		return "word"
+	}
+	return fmt.Sprintf("token(%d)", int(kind))
+}
+
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (pos Position) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  Position
+}
+
+type Lexer struct {
+	source []byte
+	offset int
+	line   int
+	column int
+}
+
+func NewLexer(reader io.Reader) (*Lexer, error) {
+	source, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Lexer{source: source, line: 1, column: 1}, nil
+}
+
+func (lexer *Lexer) Next() (Token, error) {
+	lexer.skipWhitespaceAndComments()
+	pos := Position{lexer.line, lexer.column}
+	if lexer.offset >= len(lexer.source) {
+		return Token{Kind: TokenEOF, Pos: pos}, nil
+	}
+
+	switch lexer.source[lexer.offset] {
+	case '{':
+		lexer.advance()
+		return Token{TokenLBrace, "{", pos}, nil
+	case '}':
+		lexer.advance()
+		return Token{TokenRBrace, "}", pos}, nil
+	case '(':
+		lexer.advance()
+		return Token{TokenLParen, "(", pos}, nil
+	case ')':
+		lexer.advance()
+		return Token{TokenRParen, ")", pos}, nil
+	case '"':
+		return lexer.readString(pos)
+	}
+	return lexer.readWord(pos), nil
+}
+
+func (lexer *Lexer) advance() {
+	if lexer.source[lexer.offset] == '\n' {
+		lexer.line++
+		lexer.column = 1
+	} else {
+		lexer.column++
+	}
+	lexer.offset++
+}
+
+func (lexer *Lexer) peek(distance int) byte {
+	if lexer.offset+distance >= len(lexer.source) {
+		return 0
+	}
+	return lexer.source[lexer.offset+distance]
+}
+
+func (lexer *Lexer) skipWhitespaceAndComments() {
+	for lexer.offset < len(lexer.source) {
+		character := lexer.source[lexer.offset]
+		switch {
+		case isWhitespace(character):
+			lexer.advance()
+		case character == '/' && lexer.peek(1) == '/':
+			for lexer.offset < len(lexer.source) && lexer.source[lexer.offset] != '\n' {
+				lexer.advance()
+			}
+		case character == '/' && lexer.peek(1) == '*':
+			lexer.advance()
+			lexer.advance()
+			for lexer.offset < len(lexer.source) &&
+				!(lexer.source[lexer.offset] == '*' && lexer.peek(1) == '/') {
+				lexer.advance()
+			}
+			if lexer.offset < len(lexer.source) {
+				lexer.advance()
+				lexer.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (lexer *Lexer) readString(pos Position) (Token, error) {
+	lexer.advance()
+	start := lexer.offset
+	for lexer.offset < len(lexer.source) && lexer.source[lexer.offset] != '"' {
+		if lexer.source[lexer.offset] == '\n' {
+			return Token{}, &SyntaxError{pos, "unterminated string"}
+		}
+		lexer.advance()
+	}
+	if lexer.offset >= len(lexer.source) {
+		return Token{}, &SyntaxError{pos, "unterminated string"}
+	}
+	text := string(lexer.source[start:lexer.offset])
+	lexer.advance()
+	return Token{TokenString, text, pos}, nil
+}
+
+func (lexer *Lexer) readWord(pos Position) Token {
+	start := lexer.offset
+	for lexer.offset < len(lexer.source) {
+		character := lexer.source[lexer.offset]
+		if isWhitespace(character) || isDelimiter(character) {
+			break
+		}
+		lexer.advance()
+	}
+	return Token{TokenWord, string(lexer.source[start:lexer.offset]), pos}
+}
+
+func isWhitespace(character byte) bool {
+	return character == ' ' || character == '\t' || character == '\n' || character == '\r'
+}
+
+func isDelimiter(character byte) bool {
+	switch character {
+	case '{', '}', '(', ')', '"':
+		return true
+	}
+	return false
+}
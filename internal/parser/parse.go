@@ -0,0 +1,453 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// Largest patch q3map2 accepts in either direction.
+const MaxPatchSize = 32
+
+type SyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Pos, err.Msg)
+}
+
+type mapParser struct {
+	lexer *Lexer
+	token Token
+}
+
+func ParseFile(path string) (*Map, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mapFile, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return mapFile, nil
+}
+
+func ParseFS(files fs.FS, name string) (*Map, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mapFile, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", name, err)
+	}
+	return mapFile, nil
+}
+
+func Parse(reader io.Reader) (*Map, error) {
+	lexer, err := NewLexer(reader)
+	if err != nil {
+		return nil, err
+	}
+	p := &mapParser{lexer: lexer}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	mapFile := &Map{}
+	if p.token.Kind == TokenWord && p.token.Text == "Version" {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		version, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		mapFile.Version = int(version)
+	}
+
+	for p.token.Kind != TokenEOF {
+		entity, err := p.parseEntity()
+		if err != nil {
+			return nil, err
+		}
+		mapFile.Entities = append(mapFile.Entities, entity)
+	}
+	return mapFile, nil
+}
+
+func (p *mapParser) next() error {
+	token, err := p.lexer.Next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+func (p *mapParser) expect(kind TokenKind) (Token, error) {
+	token := p.token
+	if token.Kind != kind {
+		return token, p.unexpected(kind.String())
+	}
+	return token, p.next()
+}
+
+func (p *mapParser) unexpected(expected string) error {
+	found := p.token.Kind.String()
+	if p.token.Kind == TokenWord || p.token.Kind == TokenString {
+		found = fmt.Sprintf("%q", p.token.Text)
+	}
+	return &SyntaxError{p.token.Pos, fmt.Sprintf("expected %s, found %s", expected, found)}
+}
+
+func (p *mapParser) parseEntity() (*Entity, error) {
+	open, err := p.expect(TokenLBrace)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := &Entity{Pos: open.Pos}
+	for {
+		switch p.token.Kind {
+		case TokenRBrace:
+			return entity, p.next()
+		case TokenString:
+			property, err := p.parseProperty()
+			if err != nil {
+				return nil, err
+			}
+			entity.Properties = append(entity.Properties, property)
+		case TokenLBrace:
+			if err := p.parsePrimitive(entity); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected("key, brush or '}'")
+		}
+	}
+}
+
+func (p *mapParser) parseProperty() (Property, error) {
+	key, err := p.expect(TokenString)
+	if err != nil {
+		return Property{}, err
+	}
+	value, err := p.expect(TokenString)
+	if err != nil {
+		return Property{}, err
+	}
+	return Property{key.Pos, key.Text, value.Text}, nil
+}
+
+func (p *mapParser) parsePrimitive(entity *Entity) error {
+	open, err := p.expect(TokenLBrace)
+	if err != nil {
+		return err
+	}
+
+	if p.token.Kind == TokenWord {
+		switch p.token.Text {
+		case "patchDef2", "patchDef3":
+			patch, err := p.parsePatch(open.Pos)
+			if err != nil {
+				return err
+			}
+			entity.Patches = append(entity.Patches, patch)
+			_, err = p.expect(TokenRBrace)
+			return err
+		case "brushDef", "brushDef3":
+			brush, err := p.parseBrushDef(open.Pos)
+			if err != nil {
+				return err
+			}
+			entity.Brushes = append(entity.Brushes, brush)
+			_, err = p.expect(TokenRBrace)
+			return err
+		}
+		return &SyntaxError{p.token.Pos, fmt.Sprintf("unsupported primitive %q", p.token.Text)}
+	}
+
+	brush, err := p.parseBrush(open.Pos)
+	if err != nil {
+		return err
+	}
+	entity.Brushes = append(entity.Brushes, brush)
+	return nil
+}
+
+func (p *mapParser) parseBrush(pos Position) (*Brush, error) {
+	brush := &Brush{Pos: pos}
+	for p.token.Kind != TokenRBrace {
+		face, err := p.parseBrushFace()
+		if err != nil {
+			return nil, err
+		}
+		brush.Faces = append(brush.Faces, face)
+	}
+	return brush, p.next()
+}
+
+func (p *mapParser) parseBrushFace() (*BrushFace, error) {
+	face := &BrushFace{Pos: p.token.Pos}
+	for i := range face.Plane {
+		point, err := p.parseVector(3)
+		if err != nil {
+			return nil, err
+		}
+		copy(face.Plane[i][:], point)
+	}
+
+	texture, err := p.parseTextureName()
+	if err != nil {
+		return nil, err
+	}
+	face.Texture = texture
+
+	numbers, err := p.parseTrailingNumbers()
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers) != 5 && len(numbers) != 8 {
+		return nil, &SyntaxError{
+			face.Pos,
+			fmt.Sprintf("expected 5 or 8 texture values, found %d", len(numbers)),
+		}
+	}
+	face.Shift = [2]float64{numbers[0], numbers[1]}
+	face.Rotation = numbers[2]
+	face.Scale = [2]float64{numbers[3], numbers[4]}
+	if len(numbers) == 8 {
+		face.ContentFlags = int(numbers[5])
+		face.SurfaceFlags = int(numbers[6])
+		face.Value = int(numbers[7])
+	}
+	return face, nil
+}
+
+func (p *mapParser) parseBrushDef(pos Position) (*Brush, error) {
+	brush := &Brush{Pos: pos, Format: BrushPrimitives}
+	if p.token.Text == "brushDef3" {
+		brush.Format = BrushDef3
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenLBrace); err != nil {
+		return nil, err
+	}
+
+	for p.token.Kind != TokenRBrace {
+		face, err := p.parseBrushDefFace(brush.Format)
+		if err != nil {
+			return nil, err
+		}
+		brush.Faces = append(brush.Faces, face)
+	}
+	return brush, p.next()
+}
+
+func (p *mapParser) parseBrushDefFace(format BrushFormat) (*BrushFace, error) {
+	face := &BrushFace{Pos: p.token.Pos}
+	if format == BrushDef3 {
+		equation, err := p.parseVector(4)
+		if err != nil {
+			return nil, err
+		}
+		copy(face.PlaneEquation[:], equation)
+	} else {
+		for i := range face.Plane {
+			point, err := p.parseVector(3)
+			if err != nil {
+				return nil, err
+			}
+			copy(face.Plane[i][:], point)
+		}
+	}
+
+	if _, err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	for i := range face.TexMatrix {
+		row, err := p.parseVector(3)
+		if err != nil {
+			return nil, err
+		}
+		copy(face.TexMatrix[i][:], row)
+	}
+	if _, err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+
+	texture, err := p.parseTextureName()
+	if err != nil {
+		return nil, err
+	}
+	face.Texture = texture
+
+	numbers, err := p.parseTrailingNumbers()
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers) != 0 && len(numbers) != 3 {
+		return nil, &SyntaxError{
+			face.Pos,
+			fmt.Sprintf("expected 0 or 3 flag values, found %d", len(numbers)),
+		}
+	}
+	if len(numbers) == 3 {
+		face.ContentFlags = int(numbers[0])
+		face.SurfaceFlags = int(numbers[1])
+		face.Value = int(numbers[2])
+	}
+	return face, nil
+}
+
+func (p *mapParser) parsePatch(pos Position) (*Patch, error) {
+	patch := &Patch{Pos: pos, Type: p.token.Text}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenLBrace); err != nil {
+		return nil, err
+	}
+
+	texture, err := p.parseTextureName()
+	if err != nil {
+		return nil, err
+	}
+	patch.Texture = texture
+
+	parameterCount := 5
+	if patch.Type == "patchDef3" {
+		parameterCount = 7
+	}
+	parametersPos := p.token.Pos
+	parameters, err := p.parseVector(parameterCount)
+	if err != nil {
+		return nil, err
+	}
+	for _, size := range parameters[:2] {
+		if size < 1 || size > MaxPatchSize || size != float64(int(size)) {
+			return nil, &SyntaxError{
+				parametersPos,
+				fmt.Sprintf("patch size must be a whole number from 1 to %d, found %v", MaxPatchSize, size),
+			}
+		}
+	}
+	patch.Width = int(parameters[0])
+	patch.Height = int(parameters[1])
+	if patch.Type == "patchDef3" {
+		patch.Subdivisions = [2]int{int(parameters[2]), int(parameters[3])}
+		parameters = parameters[2:]
+	}
+	patch.ContentFlags = int(parameters[2])
+	patch.SurfaceFlags = int(parameters[3])
+	patch.Value = int(parameters[4])
+
+	controlPoints, err := p.parseControlPoints(patch.Width, patch.Height)
+	if err != nil {
+		return nil, err
+	}
+	patch.ControlPoints = controlPoints
+
+	_, err = p.expect(TokenRBrace)
+	return patch, err
+}
+
+func (p *mapParser) parseControlPoints(width int, height int) ([][]PatchVertex, error) {
+	if _, err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	controlPoints := [][]PatchVertex{}
+	for p.token.Kind == TokenLParen {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		row := []PatchVertex{}
+		for p.token.Kind == TokenLParen {
+			values, err := p.parseVector(5)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, PatchVertex{
+				XYZ: [3]float64{values[0], values[1], values[2]},
+				UV:  [2]float64{values[3], values[4]},
+			})
+		}
+		if _, err := p.expect(TokenRParen); err != nil {
+			return nil, err
+		}
+		if len(row) != height {
+			return nil, &SyntaxError{
+				p.token.Pos,
+				fmt.Sprintf("expected %d control points per row, found %d", height, len(row)),
+			}
+		}
+		controlPoints = append(controlPoints, row)
+	}
+	if len(controlPoints) != width {
+		return nil, &SyntaxError{
+			p.token.Pos,
+			fmt.Sprintf("expected %d control point rows, found %d", width, len(controlPoints)),
+		}
+	}
+	_, err := p.expect(TokenRParen)
+	return controlPoints, err
+}
+
+func (p *mapParser) parseTextureName() (string, error) {
+	if p.token.Kind != TokenWord && p.token.Kind != TokenString {
+		return "", p.unexpected("texture name")
+	}
+	texture := p.token.Text
+	return texture, p.next()
+}
+
+func (p *mapParser) parseVector(size int) ([]float64, error) {
+	if _, err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	vector := make([]float64, 0, size)
+	for i := 0; i < size; i++ {
+		number, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		vector = append(vector, number)
+	}
+	_, err := p.expect(TokenRParen)
+	return vector, err
+}
+
+func (p *mapParser) parseNumber() (float64, error) {
+	if p.token.Kind != TokenWord {
+		return 0, p.unexpected("number")
+	}
+	number, err := strconv.ParseFloat(p.token.Text, 64)
+	if err != nil {
+		return 0, p.unexpected("number")
+	}
+	return number, p.next()
+}
+
+func (p *mapParser) parseTrailingNumbers() ([]float64, error) {
+	numbers := []float64{}
+	for p.token.Kind == TokenWord {
+		number, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
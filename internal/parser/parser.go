@@ -1,106 +1,225 @@
 package parser
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
 	"strings"
 
-	"gomaker/internal/brush"
+	"gomaker/internal/bsp"
 	"gomaker/internal/entity"
 	"gomaker/internal/material"
 	"gomaker/internal/shader"
 	"gomaker/internal/sound"
+	"gomaker/internal/vfs"
 )
 
-var (
-	entityLines   []string
-	parsingEntity bool
-)
+var ErrMapNotFound = errors.New("map not found")
 
-func init() {
-	parsingEntity = false
+func ReadMap(
+	mapName string,
+	baseFolderPath string,
+) (map[string]int, map[string]int, []string, []string, error) {
+	files, err := vfs.Open(baseFolderPath)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, []string{}, []string{}, err
+	}
+	defer files.Close()
+
+	materials, sounds, _, err := ReadMapMaterials(mapName, files)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, []string{}, []string{}, err
+	}
+	return ResolveDependencies(materials, sounds, files, shader.Options{})
 }
 
-func ReadMap(
+func ReadBsp(
 	mapName string,
 	baseFolderPath string,
-) (map[string]int, map[string]int, []string, []string) {
-	materials := map[string]int{}
-	sounds := map[string]int{}
-	file, err := os.Open(material.AddTrailingSlash(baseFolderPath) + "maps/" + mapName + ".map")
+) (map[string]int, map[string]int, []string, []string, error) {
+	files, err := vfs.Open(baseFolderPath)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, []string{}, []string{}, err
+	}
+	defer files.Close()
+
+	materials, sounds, _, err := ReadBspMaterials(mapName, files)
 	if err != nil {
-		fmt.Println(err)
+		return map[string]int{}, map[string]int{}, []string{}, []string{}, err
 	}
-	defer file.Close()
+	return ResolveDependencies(materials, sounds, files, shader.Options{})
+}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		AddMaterials(line, materials)
-		sound.AddSounds(line, sounds)
+func ReadMapMaterials(
+	mapName string,
+	files fs.FS,
+) (map[string]int, map[string]int, map[string]int, error) {
+	mapPath := "maps/" + mapName + ".map"
+	mapFile, err := ParseFS(files, mapPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]int{}, map[string]int{}, map[string]int{}, fmt.Errorf("%w: %s", ErrMapNotFound, mapPath)
+	}
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, err
+	}
+
+	materials, err := GetMaterials(mapFile, files)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, fmt.Errorf("%s: %w", mapPath, err)
+	}
+	models, err := GetModels(mapFile, files)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, fmt.Errorf("%s: %w", mapPath, err)
+	}
+	return materials, GetSounds(mapFile, files), models, nil
+}
+
+// Shaders baked into the bsp come from its shader lump, models loaded at
+// runtime are read through the entity lump.
+func ReadBspMaterials(
+	mapName string,
+	files fs.FS,
+) (map[string]int, map[string]int, map[string]int, error) {
+	bspPath := "maps/" + mapName + ".bsp"
+	bspFile, err := bsp.ReadFS(files, bspPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]int{}, map[string]int{}, map[string]int{}, fmt.Errorf("%w: %s", ErrMapNotFound, bspPath)
+	}
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, err
+	}
+
+	entities, err := Parse(strings.NewReader(bspFile.Entities))
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, fmt.Errorf("%s entities:%w", bspPath, err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println(err)
+	materials := GetBspMaterials(bspFile)
+	entityMaterials, err := GetMaterials(entities, files)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, fmt.Errorf("%s entities: %w", bspPath, err)
+	}
+	MergeMaps(entityMaterials, materials)
+	models, err := GetModels(entities, files)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, map[string]int{}, fmt.Errorf("%s entities: %w", bspPath, err)
 	}
+	return materials, GetSounds(entities, files), models, nil
+}
 
-	textures, shaderNames, shaderFiles := shader.ExtractTexturesFromUsedShaders(
+func ResolveDependencies(
+	materials map[string]int,
+	sounds map[string]int,
+	files fs.FS,
+	options shader.Options,
+) (map[string]int, map[string]int, []string, []string, error) {
+	textures, shaderNames, shaderFiles, err := shader.ExtractTexturesWithOptions(
 		materials,
-		fmt.Sprintf("%sscripts", material.AddTrailingSlash(baseFolderPath)),
+		files,
+		"scripts",
+		options,
 	)
+	if err != nil {
+		return map[string]int{}, map[string]int{}, []string{}, []string{}, err
+	}
 
-	textures = material.AddTexturePathWithExtension(
-		textures,
-		material.AddTrailingSlash(baseFolderPath),
-	)
+	textures = material.AddTexturePathWithExtension(textures, files)
 
-	return textures, sounds, shaderNames, shaderFiles
+	return textures, sounds, shaderNames, shaderFiles, nil
 }
 
-func AddMaterials(line string, materials map[string]int) {
-	newMaterials := GetMaterials(line)
-	if len(newMaterials) > 0 {
-		MergeMaps(newMaterials, materials)
+func GetMaterials(mapFile *Map, files fs.FS) (map[string]int, error) {
+	materials := map[string]int{}
+	for _, entity := range mapFile.Entities {
+		entityMaterials, err := HandleEntity(entity, files)
+		if err != nil {
+			return materials, fmt.Errorf("%s: %w", entity.Pos, err)
+		}
+		MergeMaps(entityMaterials, materials)
+		for _, brush := range entity.Brushes {
+			MergeMaps(HandleBrush(brush), materials)
+		}
+		for _, patch := range entity.Patches {
+			AddMaterial(patch.Texture, materials)
+		}
 	}
+	return materials, nil
 }
 
-func GetMaterials(line string) map[string]int {
+// Only brush faces and patches, the textures the bsp shader lump is built
+// from. Entity remaps and runtime models are left out.
+func GetGeometryMaterials(mapFile *Map) map[string]int {
 	materials := map[string]int{}
-	if entity.IsEntity(line) {
-		parsingEntity = true
-	} else if brush.IsBrush(line) {
-		parsingEntity = false
-	} else if IsClosingBracket(line) {
-		if len(entityLines) > 0 {
-			materials = HandleEntity(entityLines)
-			entityLines = []string{}
+	for _, entity := range mapFile.Entities {
+		for _, brush := range entity.Brushes {
+			MergeMaps(HandleBrush(brush), materials)
 		}
-	} else {
-		if parsingEntity {
-			entityLines = append(entityLines, line)
-		} else {
-			texture := material.GetMaterial(line)
-			if len(texture) > 0 {
-				materials[texture] = materials[texture] + 1
-			}
+		for _, patch := range entity.Patches {
+			AddMaterial(patch.Texture, materials)
 		}
 	}
 	return materials
 }
 
-func HandleBrush(line string) string {
-	parsingEntity = false
-	return material.GetMaterial(line)
+func GetBspMaterials(bspFile *bsp.Bsp) map[string]int {
+	materials := map[string]int{}
+	for _, shader := range bspFile.Shaders {
+		// q3map2 writes noshader for surfaces without a shader, nothing ships for it.
+		if strings.EqualFold(shader.Name, "noshader") {
+			continue
+		}
+		AddMaterial(shader.Name, materials)
+	}
+	return materials
 }
 
-func HandleEntity(lines []string) map[string]int {
-	parsingEntity = false
-	return entity.ParseEntity(lines)
+func GetSounds(mapFile *Map, files fs.FS) map[string]int {
+	sounds := map[string]int{}
+	for _, entity := range mapFile.Entities {
+		for _, property := range entity.Properties {
+			sound.AddSounds(property.Value, sounds, files)
+		}
+	}
+	return sounds
+}
+
+// Models loaded at runtime together with their skins, materials and LODs.
+func GetModels(mapFile *Map, files fs.FS) (map[string]int, error) {
+	models := map[string]int{}
+	for _, mapEntity := range mapFile.Entities {
+		keyValues := mapEntity.KeyValues()
+		for _, modelPath := range entity.RuntimeModels(keyValues) {
+			companions, err := entity.ModelCompanions(modelPath, keyValues, files)
+			if err != nil {
+				return models, fmt.Errorf("%s: %w", mapEntity.Pos, err)
+			}
+			for _, modelFile := range append([]string{modelPath}, companions...) {
+				models[modelFile] = models[modelFile] + 1
+			}
+		}
+	}
+	return models, nil
 }
 
-func IsClosingBracket(line string) bool {
-	return strings.Contains(line, "}")
+func HandleBrush(brush *Brush) map[string]int {
+	materials := map[string]int{}
+	for _, face := range brush.Faces {
+		AddMaterial(face.Texture, materials)
+	}
+	return materials
+}
+
+func HandleEntity(mapEntity *Entity, files fs.FS) (map[string]int, error) {
+	return entity.ParseKeyValues(mapEntity.KeyValues(), files)
+}
+
+// Names come from the tokenizer as written, so only the textures/ prefix the
+// engine adds back is stripped.
+func AddMaterial(texture string, materials map[string]int) {
+	texture = strings.TrimPrefix(texture, "textures/")
+	if len(texture) > 0 {
+		materials[texture] = materials[texture] + 1
+	}
 }
 
 func MergeMaps(source map[string]int, destination map[string]int) {
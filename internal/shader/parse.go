@@ -0,0 +1,353 @@
+package shader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"gomaker/internal/material"
+)
+
+type Directive struct {
+	Pos  Position
+	Name string
+	Args []string
+}
+
+type AnimMap struct {
+	Frequency float64
+	Frames    []string
+}
+
+type SkyParms struct {
+	FarBox      string
+	CloudHeight string
+	NearBox     string
+}
+
+type Stage struct {
+	Pos        Position
+	Directives []Directive
+	Map        string
+	Clamp      bool
+	AnimMap    *AnimMap
+	VideoMap   string
+	BlendFunc  []string
+	RgbGen     []string
+	AlphaGen   []string
+	TcGen      []string
+	TcMod      [][]string
+	AlphaFunc  string
+	DepthFunc  string
+	DepthWrite bool
+	Detail     bool
+}
+
+type shaderParser struct {
+	lexer    *Lexer
+	token    Token
+	lastLine int
+}
+
+func ParseFile(path string) ([]Shader, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	shaders, err := Parse(bytes.NewReader(source))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return shaders, nil
+}
+
+func ParseFS(files fs.FS, name string) ([]Shader, error) {
+	source, err := fs.ReadFile(files, name)
+	if err != nil {
+		return nil, err
+	}
+
+	shaders, err := Parse(bytes.NewReader(source))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", name, err)
+	}
+	return shaders, nil
+}
+
+func Parse(reader io.Reader) ([]Shader, error) {
+	source, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	lexer, err := NewLexer(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	p := &shaderParser{lexer: lexer}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(source), "\n")
+	shaders := []Shader{}
+	for p.token.Kind != TokenEOF {
+		shader, err := p.parseShader()
+		if err != nil {
+			return nil, err
+		}
+		shader.Lines = sourceLines(lines, shader.Pos.Line, p.lastLine)
+		shaders = append(shaders, shader)
+	}
+	return shaders, nil
+}
+
+func (p *shaderParser) next() error {
+	token, err := p.lexer.Next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+func (p *shaderParser) unexpected(expected string) error {
+	found := "end of file"
+	switch p.token.Kind {
+	case TokenLBrace:
+		found = "'{'"
+	case TokenRBrace:
+		found = "'}'"
+	case TokenWord:
+		found = fmt.Sprintf("%q", p.token.Text)
+	}
+	return &SyntaxError{p.token.Pos, fmt.Sprintf("expected %s, found %s", expected, found)}
+}
+
+func (p *shaderParser) parseShader() (Shader, error) {
+	if p.token.Kind != TokenWord {
+		return Shader{}, p.unexpected("shader name")
+	}
+	shader := Shader{
+		Name:     material.FormatPath(p.token.Text),
+		Pos:      p.token.Pos,
+		Textures: map[string]int{},
+	}
+	if err := p.next(); err != nil {
+		return Shader{}, err
+	}
+	if p.token.Kind != TokenLBrace {
+		return Shader{}, p.unexpected("'{'")
+	}
+	if err := p.next(); err != nil {
+		return Shader{}, err
+	}
+
+	for {
+		switch p.token.Kind {
+		case TokenRBrace:
+			p.lastLine = p.token.Pos.Line
+			for _, image := range shader.Images() {
+				texture := material.GetMaterial(image)
+				if material.IsVideo(image) {
+					texture = image
+				}
+				if len(texture) > 0 {
+					shader.Textures[texture] = shader.Textures[texture] + 1
+				}
+			}
+			return shader, p.next()
+		case TokenLBrace:
+			stage, err := p.parseStage()
+			if err != nil {
+				return Shader{}, err
+			}
+			shader.Stages = append(shader.Stages, stage)
+		case TokenWord:
+			directive, err := p.parseDirective()
+			if err != nil {
+				return Shader{}, err
+			}
+			shader.addDirective(directive)
+		default:
+			return Shader{}, p.unexpected("directive, stage or '}'")
+		}
+	}
+}
+
+func (p *shaderParser) parseStage() (Stage, error) {
+	stage := Stage{Pos: p.token.Pos}
+	if err := p.next(); err != nil {
+		return Stage{}, err
+	}
+
+	for {
+		switch p.token.Kind {
+		case TokenRBrace:
+			return stage, p.next()
+		case TokenWord:
+			directive, err := p.parseDirective()
+			if err != nil {
+				return Stage{}, err
+			}
+			stage.addDirective(directive)
+		default:
+			return Stage{}, p.unexpected("stage directive or '}'")
+		}
+	}
+}
+
+func (p *shaderParser) parseDirective() (Directive, error) {
+	directive := Directive{Pos: p.token.Pos, Name: p.token.Text, Args: []string{}}
+	if err := p.next(); err != nil {
+		return Directive{}, err
+	}
+	for p.token.Kind == TokenWord && p.token.Pos.Line == directive.Pos.Line {
+		directive.Args = append(directive.Args, p.token.Text)
+		if err := p.next(); err != nil {
+			return Directive{}, err
+		}
+	}
+	return directive, nil
+}
+
+func (shader *Shader) addDirective(directive Directive) {
+	shader.Directives = append(shader.Directives, directive)
+	switch strings.ToLower(directive.Name) {
+	case "surfaceparm":
+		shader.SurfaceParms = append(shader.SurfaceParms, directive.Args...)
+	case "cull":
+		shader.Cull = directive.Arg(0)
+	case "deformvertexes":
+		shader.DeformVertexes = append(shader.DeformVertexes, directive.Args)
+	case "skyparms":
+		shader.SkyParms = &SkyParms{directive.Arg(0), directive.Arg(1), directive.Arg(2)}
+	}
+}
+
+func (stage *Stage) addDirective(directive Directive) {
+	stage.Directives = append(stage.Directives, directive)
+	switch strings.ToLower(directive.Name) {
+	case "map":
+		stage.Map = directive.Arg(0)
+	case "clampmap":
+		stage.Map = directive.Arg(0)
+		stage.Clamp = true
+	case "animmap":
+		frequency, _ := strconv.ParseFloat(directive.Arg(0), 64)
+		frames := []string{}
+		if len(directive.Args) > 1 {
+			frames = directive.Args[1:]
+		}
+		stage.AnimMap = &AnimMap{frequency, frames}
+	case "videomap":
+		stage.VideoMap = directive.Arg(0)
+	case "blendfunc":
+		stage.BlendFunc = directive.Args
+	case "rgbgen":
+		stage.RgbGen = directive.Args
+	case "alphagen":
+		stage.AlphaGen = directive.Args
+	case "tcgen":
+		stage.TcGen = directive.Args
+	case "tcmod":
+		stage.TcMod = append(stage.TcMod, directive.Args)
+	case "alphafunc":
+		stage.AlphaFunc = directive.Arg(0)
+	case "depthfunc":
+		stage.DepthFunc = directive.Arg(0)
+	case "depthwrite":
+		stage.DepthWrite = true
+	case "detail":
+		stage.Detail = true
+	}
+}
+
+func (directive Directive) Arg(index int) string {
+	if index < len(directive.Args) {
+		return directive.Args[index]
+	}
+	return ""
+}
+
+func (shader *Shader) Q3MapDirectives() []Directive {
+	directives := []Directive{}
+	for _, directive := range shader.Directives {
+		if strings.HasPrefix(strings.ToLower(directive.Name), "q3map_") {
+			directives = append(directives, directive)
+		}
+	}
+	return directives
+}
+
+func (shader *Shader) Images() []string {
+	images := []string{}
+	for _, directive := range shader.Directives {
+		switch strings.ToLower(directive.Name) {
+		case "q3map_lightimage", "q3map_normalimage":
+			if IsImage(directive.Arg(0)) {
+				images = append(images, directive.Arg(0))
+			}
+		}
+	}
+	if shader.SkyParms != nil {
+		images = append(images, SkyBoxImages(shader.SkyParms.FarBox)...)
+		images = append(images, SkyBoxImages(shader.SkyParms.NearBox)...)
+	}
+	for _, stage := range shader.Stages {
+		if IsImage(stage.Map) {
+			images = append(images, stage.Map)
+		}
+		if stage.AnimMap != nil {
+			for _, frame := range stage.AnimMap.Frames {
+				if IsImage(frame) {
+					images = append(images, frame)
+				}
+			}
+		}
+		if len(stage.VideoMap) > 0 {
+			images = append(images, VideoPath(stage.VideoMap))
+		}
+	}
+	return images
+}
+
+// The engine looks a video up in video/ unless its name has a directory.
+func VideoPath(videoMap string) string {
+	videoMap = strings.ReplaceAll(videoMap, "\\", "/")
+	if strings.Contains(videoMap, "/") {
+		return videoMap
+	}
+	return "video/" + videoMap
+}
+
+func SkyBoxImages(box string) []string {
+	images := []string{}
+	if box == "-" || !IsImage(box) {
+		return images
+	}
+	// Same suffix order the engine uses when loading the six box sides
+	for _, suffix := range []string{"rt", "lf", "bk", "ft", "up", "dn"} {
+		images = append(images, fmt.Sprintf("%s_%s", box, suffix))
+	}
+	return images
+}
+
+func IsImage(path string) bool {
+	return len(path) > 0 && !strings.HasPrefix(path, "$") && !strings.HasPrefix(path, "*")
+}
+
+func sourceLines(lines []string, first int, last int) []string {
+	if first < 1 || last > len(lines) || first > last {
+		return []string{}
+	}
+	shaderLines := make([]string, 0, last-first+1)
+	for _, line := range lines[first-1 : last] {
+		shaderLines = append(shaderLines, strings.TrimSuffix(line, "\r"))
+	}
+	return shaderLines
+}
@@ -1,51 +1,317 @@
 package shader
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"regexp"
+	"path"
+	"slices"
 	"strings"
 
 	"gomaker/internal/material"
 )
 
 type Shader struct {
-	Name     string
-	Lines    []string
-	Textures map[string]int
+	Name           string
+	Lines          []string
+	Textures       map[string]int
+	File           string
+	Pos            Position
+	Directives     []Directive
+	SurfaceParms   []string
+	Cull           string
+	DeformVertexes [][]string
+	SkyParms       *SkyParms
+	Stages         []Stage
+}
+
+type ShaderFile struct {
+	Name    string
+	Shaders []Shader
+}
+
+var shaderReferenceDirectives = []string{
+	"q3map_baseshader",
+	"q3map_remapshader",
+	"q3map_backshader",
+	"q3map_cloneshader",
+	"q3map_flare",
+}
+
+type Options struct {
+	UseShaderList bool
+	Output        io.Writer
+}
+
+// Messages go to Output, or to stdout when it is not set.
+func (options Options) Writer() io.Writer {
+	if options.Output == nil {
+		return os.Stdout
+	}
+	return options.Output
+}
+
+type Definition struct {
+	File string
+	Line int
+}
+
+type Duplicate struct {
+	Name        string
+	Used        Definition
+	Definitions []Definition
 }
 
 func ExtractTexturesFromUsedShaders(
 	shadersFromMapFile map[string]int,
 	shaderFolderPath string,
-) (map[string]int, []string, []string) {
+) (map[string]int, []string, []string, error) {
+	return ExtractTexturesWithOptions(shadersFromMapFile, os.DirFS(shaderFolderPath), ".", Options{})
+}
+
+func ExtractTexturesWithOptions(
+	shadersFromMapFile map[string]int,
+	fileSystem fs.FS,
+	shaderFolder string,
+	options Options,
+) (map[string]int, []string, []string, error) {
 	shaderFiles := []string{}
 	textures := map[string]int{}
 	shaderNames := []string{}
 
-	fsPath := material.AddTrailingSlash(shaderFolderPath)
-	directory, err := os.ReadDir(fsPath)
+	files, err := LoadShaderFiles(fileSystem, shaderFolder, options)
+	if err != nil {
+		return textures, shaderNames, shaderFiles, err
+	}
+	index := IndexShaders(files)
+	used := ResolveUsedShaders(shadersFromMapFile, index)
 
-	for _, file := range directory {
-		shaderFileName := file.Name()
-		shaders := ParseShaderFile(shadersFromMapFile, shaderFileName, shaderFolderPath)
-		for _, shader := range shaders {
-			delete(shadersFromMapFile, shader.Name)
+	for _, duplicate := range FindDuplicates(files) {
+		if ShaderIsUsed(used, duplicate.Name) {
+			PrintDuplicate(options.Writer(), duplicate)
+		}
+	}
+
+	for _, file := range files {
+		shaders := []Shader{}
+		for _, shader := range file.Shaders {
+			indexed := index[ShaderKey(shader.Name)]
+			if ShaderIsUsed(used, shader.Name) && indexed.Definition() == shader.Definition() {
+				shaders = append(shaders, shader)
+			}
 		}
 		if len(shaders) > 0 {
-			shaderFiles = append(shaderFiles, shaderFileName)
+			shaderFiles = append(shaderFiles, file.Name)
 			textures, shaderNames = CombineTexturesFromShaders(shaders, textures, shaderNames)
 		}
 	}
 
+	for name, count := range used {
+		if _, defined := index[ShaderKey(name)]; !defined {
+			textures[name] = count
+		}
+	}
+	return textures, shaderNames, shaderFiles, nil
+}
+
+func LoadShaderFiles(
+	fileSystem fs.FS,
+	shaderFolder string,
+	options Options,
+) ([]ShaderFile, error) {
+	files := []ShaderFile{}
+	fileNames := []string{}
+	if options.UseShaderList {
+		listed, err := ReadShaderList(fileSystem, path.Join(shaderFolder, "shaderlist.txt"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return files, err
+		}
+		if err != nil {
+			fmt.Fprintln(options.Writer(), "No shaderlist.txt, loading every shader file")
+		}
+		fileNames = listed
+	}
+
+	if len(fileNames) == 0 {
+		directory, err := fs.ReadDir(fileSystem, shaderFolder)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return files, err
+		}
+		for _, file := range directory {
+			if !file.IsDir() && strings.HasSuffix(strings.ToLower(file.Name()), ".shader") {
+				fileNames = append(fileNames, file.Name())
+			}
+		}
+	}
+
+	// A broken shader file somewhere in the game directories should not stop
+	// every build, the shaders the map needs are checked later.
+	for _, fileName := range fileNames {
+		shaders, err := ParseFS(fileSystem, path.Join(shaderFolder, fileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(options.Writer(), "Skipping %s, it is listed in shaderlist.txt but does not exist\n", fileName)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(options.Writer(), "Skipping shader file that failed to parse, %s\n", err)
+			continue
+		}
+		for index := range shaders {
+			shaders[index].File = fileName
+		}
+		files = append(files, ShaderFile{fileName, shaders})
+	}
+	return files, nil
+}
+
+func ReadShaderList(fileSystem fs.FS, listPath string) ([]string, error) {
+	content, err := fs.ReadFile(fileSystem, listPath)
 	if err != nil {
-		fmt.Printf("Failed opening directory %v with path %s, error %s", directory, fsPath, err)
+		return []string{}, err
 	}
-	for key, value := range shadersFromMapFile {
-		textures[key] = value
+
+	fileNames := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		name := strings.TrimSpace(line)
+		if len(name) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(strings.ToLower(name), ".shader") {
+			name = name + ".shader"
+		}
+		if !slices.Contains(fileNames, name) {
+			fileNames = append(fileNames, name)
+		}
 	}
-	return textures, shaderNames, shaderFiles
+	return fileNames, nil
+}
+
+// Mirrors the engine lookup: a definition in a later file replaces one from an
+// earlier file, while a repeated name within the same file keeps the first one.
+// Names compare case-insensitively like in the engine, so the index is keyed by
+// ShaderKey.
+func IndexShaders(files []ShaderFile) map[string]Shader {
+	index := map[string]Shader{}
+	for _, file := range files {
+		seen := map[string]bool{}
+		for _, shader := range file.Shaders {
+			key := ShaderKey(shader.Name)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			index[key] = shader
+		}
+	}
+	return index
+}
+
+func ShaderKey(name string) string {
+	return strings.ToLower(name)
+}
+
+func FindDuplicates(files []ShaderFile) []Duplicate {
+	index := IndexShaders(files)
+	definitions := map[string][]Definition{}
+	names := []string{}
+	for _, file := range files {
+		for _, shader := range file.Shaders {
+			key := ShaderKey(shader.Name)
+			if _, ok := definitions[key]; !ok {
+				names = append(names, shader.Name)
+			}
+			definitions[key] = append(definitions[key], shader.Definition())
+		}
+	}
+
+	duplicates := []Duplicate{}
+	for _, name := range names {
+		key := ShaderKey(name)
+		if len(definitions[key]) > 1 {
+			duplicates = append(duplicates, Duplicate{name, index[key].Definition(), definitions[key]})
+		}
+	}
+	return duplicates
+}
+
+func PrintDuplicate(output io.Writer, duplicate Duplicate) {
+	fmt.Fprintf(
+		output,
+		"Shader %s is defined %d times, using %s:%d\n",
+		duplicate.Name,
+		len(duplicate.Definitions),
+		duplicate.Used.File,
+		duplicate.Used.Line,
+	)
+	for _, definition := range duplicate.Definitions {
+		if definition != duplicate.Used {
+			fmt.Fprintf(output, "  ignored definition in %s:%d\n", definition.File, definition.Line)
+		}
+	}
+}
+
+// Follows q3map_* references from the used shaders, so shaders that are only
+// pulled in by another shader are treated as used as well.
+func ResolveUsedShaders(shadersFromMapFile map[string]int, index map[string]Shader) map[string]int {
+	used := map[string]int{}
+	seen := map[string]bool{}
+	queue := []string{}
+	for name, count := range shadersFromMapFile {
+		used[name] = count
+		seen[ShaderKey(name)] = true
+		queue = append(queue, name)
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		shader, ok := index[ShaderKey(name)]
+		if !ok {
+			continue
+		}
+		for _, reference := range shader.ShaderReferences() {
+			if !seen[ShaderKey(reference)] {
+				seen[ShaderKey(reference)] = true
+				used[reference] = 1
+				queue = append(queue, reference)
+			}
+		}
+	}
+	return used
+}
+
+func TrimmedShaderFile(mapName string, shaders []Shader) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "// Shaders used by %s\n", mapName)
+	for _, shader := range shaders {
+		builder.WriteString("\n")
+		for _, line := range shader.Lines {
+			builder.WriteString(line)
+			builder.WriteString("\n")
+		}
+	}
+	return builder.String()
+}
+
+func (shader Shader) Definition() Definition {
+	return Definition{shader.File, shader.Pos.Line}
+}
+
+func (shader *Shader) ShaderReferences() []string {
+	references := []string{}
+	for _, directive := range shader.Directives {
+		if !slices.Contains(shaderReferenceDirectives, strings.ToLower(directive.Name)) {
+			continue
+		}
+		reference := strings.TrimPrefix(directive.Arg(0), "textures/")
+		if len(reference) > 0 && !strings.EqualFold(reference, shader.Name) {
+			references = append(references, reference)
+		}
+	}
+	return references
 }
 
 func CombineTexturesFromShaders(
@@ -66,65 +332,30 @@ func ParseShaderFile(
 	shadersFromMapFile map[string]int,
 	shaderFileName string,
 	shaderFolderPath string,
-) []Shader {
-	fsPath := material.AddTrailingSlash(shaderFolderPath) + shaderFileName
-	file, err := os.Open(fsPath)
+) ([]Shader, error) {
+	shaders := []Shader{}
+	parsed, err := ParseFile(material.AddTrailingSlash(shaderFolderPath) + shaderFileName)
 	if err != nil {
-		fmt.Printf("Failed opening file %v with path %s, error %s", file, shaderFileName, err)
+		return shaders, err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	shaders := []Shader{}
-	shader := Shader{"", []string{}, map[string]int{}}
-	parsingShader := false
-	brackets := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "qer_editorimage") {
-			continue
-		}
-		texture := material.GetMaterial(line)
-		if parsingShader {
-			shader.Lines = append(shader.Lines, line)
-		}
-		if len(texture) > 0 {
-			isShaderName := IsShaderName(line)
-			if isShaderName && ShaderIsUsed(shadersFromMapFile, texture) {
-				parsingShader = true
-				shader.Name = texture
-				shader.Lines = append(shader.Lines, line)
-			} else if parsingShader {
-				shader.Textures[texture] = shader.Textures[texture] + 1
-			}
-		}
-		if strings.Contains(line, "{") {
-			brackets++
-		} else if strings.Contains(line, "}") {
-			brackets--
-			if brackets == 0 && parsingShader {
-				shaders = append(shaders, shader)
-				shader = Shader{"", []string{}, map[string]int{}}
-				parsingShader = false
-			}
+	for _, shader := range parsed {
+		if ShaderIsUsed(shadersFromMapFile, shader.Name) {
+			shader.File = shaderFileName
+			shaders = append(shaders, shader)
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		fmt.Println(err)
-	}
-	return shaders
+	return shaders, nil
 }
 
 func ShaderIsUsed(shadersFromMapFile map[string]int, shaderName string) bool {
-	_, ok := shadersFromMapFile[shaderName]
-	return ok
-}
-
-func IsShaderName(line string) bool {
-	line = strings.Replace(line, "{", "", 1)
-	line = strings.TrimSpace(line)
-	hasWhitespaceRegexp := regexp.MustCompile(`\s`)
-	hasWhitespace := hasWhitespaceRegexp.FindString(line)
-	return len(hasWhitespace) == 0
+	if _, ok := shadersFromMapFile[shaderName]; ok {
+		return true
+	}
+	for name := range shadersFromMapFile {
+		if strings.EqualFold(name, shaderName) {
+			return true
+		}
+	}
+	return false
 }
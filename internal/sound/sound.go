@@ -2,22 +2,29 @@ package sound
 
 import (
 	"fmt"
+	"io/fs"
 	"regexp"
 	"strings"
 )
 
-func GetSound(line string) string {
-	soundFile := ""
+// Like textures, a sound that is in none of the game directories or pk3s is
+// left out instead of failing the build, the engine plays nothing for it.
+func GetSound(line string, files fs.FS) string {
 	soundRegex := regexp.MustCompile(`((\w+[\/_-]*)+\/((\w)+[\/_-]*)*)+`)
 	sound := soundRegex.FindString(line)
-	if strings.Contains(line, ".wav") && !strings.Contains(line, "sound/world/") {
-		soundFile = fmt.Sprintf("%s.wav", sound)
+	if !strings.Contains(line, ".wav") {
+		return ""
+	}
+	soundFile := fmt.Sprintf("%s.wav", sound)
+	_, err := fs.Stat(files, soundFile)
+	if err != nil {
+		return ""
 	}
 	return soundFile
 }
 
-func AddSounds(line string, sounds map[string]int) {
-	sound := GetSound(line)
+func AddSounds(line string, sounds map[string]int, files fs.FS) {
+	sound := GetSound(line, files)
 	if len(sound) > 0 {
 		sounds[sound] = sounds[sound] + 1
 	}
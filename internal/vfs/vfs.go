@@ -0,0 +1,344 @@
+package vfs
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Source struct {
+	Path    string
+	Archive bool
+}
+
+// Search order mirrors the engine: every directory contributes its pk3 files
+// in alphabetical order followed by its loose files, and a later layer wins.
+type FS struct {
+	Directories []string
+	Archives    []string
+	layers      []*layer
+}
+
+type layer struct {
+	path     string
+	archive  *zip.ReadCloser
+	files    map[string]*zip.File
+	children map[string]map[string]fs.DirEntry
+}
+
+type archiveFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+type dirInfo struct {
+	name string
+}
+
+type dirFile struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func Open(directories ...string) (*FS, error) {
+	return OpenExcluding([]string{}, directories...)
+}
+
+// Leaves out the given archives, e.g. the pk3 a build is about to replace so
+// its old contents are not packed again.
+func OpenExcluding(excluded []string, directories ...string) (*FS, error) {
+	vfs := &FS{Directories: []string{}, Archives: []string{}, layers: []*layer{}}
+	for _, directory := range directories {
+		archives, err := FindArchives(directory)
+		if err != nil {
+			vfs.Close()
+			return nil, err
+		}
+		for _, archive := range archives {
+			if isExcluded(archive, excluded) {
+				continue
+			}
+			archiveLayer, err := openArchive(archive)
+			if err != nil {
+				vfs.Close()
+				return nil, err
+			}
+			vfs.Archives = append(vfs.Archives, archive)
+			vfs.layers = append(vfs.layers, archiveLayer)
+		}
+		vfs.Directories = append(vfs.Directories, directory)
+		vfs.layers = append(vfs.layers, &layer{path: directory})
+	}
+	return vfs, nil
+}
+
+func FindArchives(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	archives := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".pk3") {
+			archives = append(archives, filepath.Join(directory, entry.Name()))
+		}
+	}
+	slices.SortFunc(archives, func(a string, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	return archives, nil
+}
+
+func isExcluded(archive string, excluded []string) bool {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return false
+	}
+	for _, excludedPath := range excluded {
+		excludedInfo, err := os.Stat(excludedPath)
+		if err == nil && os.SameFile(info, excludedInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+func openArchive(archivePath string) (*layer, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", archivePath, err)
+	}
+
+	archiveLayer := &layer{
+		path:     archivePath,
+		archive:  reader,
+		files:    map[string]*zip.File{},
+		children: map[string]map[string]fs.DirEntry{},
+	}
+	for _, file := range reader.File {
+		name := strings.Trim(strings.ReplaceAll(file.Name, "\\", "/"), "/")
+		if len(name) == 0 || !fs.ValidPath(name) {
+			continue
+		}
+		if !strings.HasSuffix(file.Name, "/") {
+			archiveLayer.files[strings.ToLower(name)] = file
+			archiveLayer.addChild(path.Dir(name), fs.FileInfoToDirEntry(file.FileInfo()))
+		}
+		for parent := name; parent != "."; parent = path.Dir(parent) {
+			if parent != name || strings.HasSuffix(file.Name, "/") {
+				archiveLayer.addChild(path.Dir(parent), fs.FileInfoToDirEntry(dirInfo{path.Base(parent)}))
+			}
+		}
+	}
+	return archiveLayer, nil
+}
+
+func (archiveLayer *layer) addChild(parent string, entry fs.DirEntry) {
+	key := strings.ToLower(parent)
+	if archiveLayer.children[key] == nil {
+		archiveLayer.children[key] = map[string]fs.DirEntry{}
+	}
+	archiveLayer.children[key][strings.ToLower(entry.Name())] = entry
+}
+
+func (vfs *FS) Close() error {
+	errs := []error{}
+	for _, archiveLayer := range vfs.layers {
+		if archiveLayer.archive != nil {
+			errs = append(errs, archiveLayer.archive.Close())
+		}
+	}
+	vfs.layers = []*layer{}
+	return errors.Join(errs...)
+}
+
+func (vfs *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var dir fs.FileInfo
+	if name == "." {
+		dir = dirInfo{name}
+	}
+	for index := len(vfs.layers) - 1; index >= 0 && dir == nil; index-- {
+		current := vfs.layers[index]
+		if current.archive == nil {
+			info, err := os.Stat(filepath.Join(current.path, filepath.FromSlash(name)))
+			if err == nil && info.IsDir() {
+				dir = info
+			} else if err == nil {
+				return os.Open(filepath.Join(current.path, filepath.FromSlash(name)))
+			}
+			continue
+		}
+
+		key := strings.ToLower(name)
+		if file, ok := current.files[key]; ok {
+			reader, err := file.Open()
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+			return &archiveFile{reader, file.FileInfo()}, nil
+		}
+		if _, ok := current.children[key]; ok {
+			dir = dirInfo{path.Base(name)}
+		}
+	}
+
+	if dir == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := vfs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{info: dir, entries: entries}, nil
+}
+
+func (vfs *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	found := false
+	merged := map[string]fs.DirEntry{}
+	for _, current := range vfs.layers {
+		if current.archive == nil {
+			entries, err := os.ReadDir(filepath.Join(current.path, filepath.FromSlash(name)))
+			if err != nil {
+				continue
+			}
+			found = true
+			for _, entry := range entries {
+				merged[strings.ToLower(entry.Name())] = entry
+			}
+			continue
+		}
+
+		children, ok := current.children[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		found = true
+		for key, entry := range children {
+			merged[key] = entry
+		}
+	}
+	if !found && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := []fs.DirEntry{}
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a fs.DirEntry, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// Reports which loose directory or pk3 archive a file is served from.
+func (vfs *FS) Source(name string) (Source, bool) {
+	if !fs.ValidPath(name) {
+		return Source{}, false
+	}
+	for index := len(vfs.layers) - 1; index >= 0; index-- {
+		current := vfs.layers[index]
+		if current.archive == nil {
+			info, err := os.Stat(filepath.Join(current.path, filepath.FromSlash(name)))
+			if err == nil && !info.IsDir() {
+				return Source{current.path, false}, true
+			}
+			continue
+		}
+		if _, ok := current.files[strings.ToLower(name)]; ok {
+			return Source{current.path, true}, true
+		}
+	}
+	return Source{}, false
+}
+
+// Lists the files in one of the opened archives, so indexes over pk3 contents
+// do not have to read the archive again.
+func (vfs *FS) ArchiveFiles(archivePath string) ([]string, bool) {
+	for _, current := range vfs.layers {
+		if current.archive == nil || current.path != archivePath {
+			continue
+		}
+		names := []string{}
+		for _, file := range current.files {
+			names = append(names, strings.Trim(strings.ReplaceAll(file.Name, "\\", "/"), "/"))
+		}
+		slices.Sort(names)
+		return names, true
+	}
+	return nil, false
+}
+
+func (file *archiveFile) Stat() (fs.FileInfo, error) {
+	return file.info, nil
+}
+
+func (info dirInfo) Name() string {
+	return info.name
+}
+
+func (info dirInfo) Size() int64 {
+	return 0
+}
+
+func (info dirInfo) Mode() fs.FileMode {
+	return fs.ModeDir | 0555
+}
+
+func (info dirInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (info dirInfo) IsDir() bool {
+	return true
+}
+
+func (info dirInfo) Sys() any {
+	return nil
+}
+
+func (dir *dirFile) Stat() (fs.FileInfo, error) {
+	return dir.info, nil
+}
+
+func (dir *dirFile) Read(buffer []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: dir.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (dir *dirFile) Close() error {
+	return nil
+}
+
+func (dir *dirFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := dir.entries[dir.offset:]
+	if count <= 0 {
+		dir.offset = len(dir.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	dir.offset += count
+	return remaining[:count], nil
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gomaker/internal/bsp"
+)
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		path            string
+		expectedIdent   string
+		expectedVersion int32
+		expectedLumps   int
+		expectedShaders []bsp.Shader
+	}{
+		{
+			"data/baseq3/maps/bsponly.bsp",
+			"IBSP",
+			46,
+			17,
+			[]bsp.Shader{
+				{Name: "textures/testmap/test_texture", SurfaceFlags: 0, ContentFlags: 1},
+				{Name: "textures/common/caulk", SurfaceFlags: 128, ContentFlags: 1},
+				{Name: "textures/testmap/test_shader", SurfaceFlags: 0, ContentFlags: 1},
+				{Name: "textures/testmap/test_model_texture_1", SurfaceFlags: 0, ContentFlags: 1},
+				{Name: "noshader", SurfaceFlags: 0, ContentFlags: 0},
+			},
+		},
+		{
+			"data/baseq3/maps/rbsponly.bsp",
+			"RBSP",
+			1,
+			18,
+			[]bsp.Shader{
+				{Name: "textures/testmap/test_texture", SurfaceFlags: 0, ContentFlags: 1},
+				{Name: "textures/common/caulk", SurfaceFlags: 128, ContentFlags: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := bsp.ReadFile(test.path)
+		if err != nil {
+			t.Fatalf("ReadFile failed for %s: %s", test.path, err)
+		}
+		if actual.Ident != test.expectedIdent || actual.Version != test.expectedVersion {
+			t.Errorf(
+				"Expected %s %d got %s %d",
+				test.expectedIdent,
+				test.expectedVersion,
+				actual.Ident,
+				actual.Version,
+			)
+		}
+		if len(actual.Lumps) != test.expectedLumps {
+			t.Errorf("Expected %d lumps got %d", test.expectedLumps, len(actual.Lumps))
+		}
+		if !reflect.DeepEqual(actual.Shaders, test.expectedShaders) {
+			t.Errorf("Expected %v got %v", test.expectedShaders, actual.Shaders)
+		}
+		if !bytes.Contains([]byte(actual.Entities), []byte(`"classname" "worldspawn"`)) {
+			t.Errorf("Expected worldspawn in entities lump got %s", actual.Entities)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte{}, "file too short for a bsp header (0 bytes)"},
+		{[]byte("VBSP\x14\x00\x00\x00"), `unsupported bsp ident "VBSP"`},
+		{[]byte("IBSP\x2f\x00\x00\x00"), "unsupported IBSP version 47, expected 46"},
+		{[]byte("IBSP\x2e\x00\x00\x00\x00"), "file too short for 17 lumps (9 bytes)"},
+	}
+
+	for _, test := range tests {
+		_, err := bsp.Read(bytes.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %s got %v", test.expected, err)
+		}
+	}
+}
+
+func TestShaderNames(t *testing.T) {
+	bspFile := &bsp.Bsp{Shaders: []bsp.Shader{{Name: "textures/a/b"}, {Name: "noshader"}}}
+	expected := []string{"textures/a/b", "noshader"}
+	actual := bspFile.ShaderNames()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
@@ -2,35 +2,39 @@ package test
 
 import (
 	"archive/zip"
-	"fmt"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
 	"slices"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"gomaker/internal/builder"
+	"gomaker/internal/config"
+	"gomaker/internal/pak"
+	"gomaker/internal/vfs"
 )
 
 func TestBuildPk3(t *testing.T) {
 	expected := []string{
-		"/",
 		"testmap.txt",
-		"cfg-maps/",
 		"cfg-maps/testmap.cfg",
-		"levelshots/",
 		"levelshots/testmap.jpg",
-		"maps/",
 		"maps/testmap.bsp",
 		"maps/testmap.map",
 		"maps/testmap/lm_0000.tga",
 		"maps/testmap/lm_0001.tga",
 		"maps/testmap/lm_0002.tga",
-		"scripts/",
 		"scripts/testmap.arena",
 		"scripts/testmap.shader",
 		"scripts/test_shader_2.shader",
-		"sound/",
 		"sound/testmap/sound-file.wav",
-		"textures/",
 		"textures/testmap/test_model_texture_1.jpg",
 		"textures/testmap/test_model_texture_2.tga",
 		"textures/testmap/test_shader_2.tga",
@@ -39,9 +43,16 @@ func TestBuildPk3(t *testing.T) {
 		"textures/testmap/test_texture_3.tga",
 	}
 
-	pk3Path := builder.BuildPk3("testmap", "data/baseq3")
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"testmap",
+		"data/baseq3",
+		builder.Options{OutputPath: t.TempDir()},
+	)
+	if err != nil {
+		t.Fatalf("BuildPk3 returned error %s", err)
+	}
 
-	_, err := os.Stat(pk3Path)
+	_, err = os.Stat(pk3Path)
 	if err != nil {
 		t.Fatalf("PK3 does not exist: %s", err)
 	}
@@ -66,108 +77,634 @@ func TestBuildPk3(t *testing.T) {
 	}
 }
 
-func TestCreatePk3(t *testing.T) {
-	resources := []string{"scripts/testmap.arena", "levelshots/testmap.jpg", "maps/testmap.map"}
-	pk3Path := builder.CreatePk3("data/baseq3", resources, "testmap")
-
+func TestBuildPk3FromBsp(t *testing.T) {
 	expected := []string{
-		"/",
-		"levelshots/",
-		"levelshots/testmap.jpg",
-		"maps/",
-		"maps/testmap.map",
-		"scripts/",
-		"scripts/testmap.arena",
+		"maps/bsponly.bsp",
+		"scripts/testmap.shader",
+		"sound/testmap/sound-file.wav",
+		"textures/testmap/test_model_texture_1.jpg",
+		"textures/testmap/test_shader_2.tga",
+		"textures/testmap/test_shader_3.jpg",
+		"textures/testmap/test_texture.jpg",
 	}
 
-	_, err := os.Stat(pk3Path)
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"bsponly",
+		"data/baseq3",
+		builder.Options{OutputPath: t.TempDir()},
+	)
 	if err != nil {
-		t.Errorf("PK3 does not exist: %s", err)
+		t.Fatalf("BuildPk3 returned error %s", err)
 	}
 
 	readCloser, err := zip.OpenReader(pk3Path)
 	if err != nil {
-		t.Errorf("Open reader blew up: %s", err)
+		t.Fatalf("Open reader blew up: %s", err)
 	}
 	defer readCloser.Close()
 
-	numOfPaths := len(readCloser.File)
+	actual := []string{}
 	for _, f := range readCloser.File {
-		path := f.Name
-		if !slices.Contains(expected, path) {
-			t.Errorf("Expected %s to be in %v", path, expected)
+		if !strings.HasSuffix(f.Name, "/") {
+			actual = append(actual, f.Name)
 		}
 	}
+	slices.Sort(actual)
 
-	expectedNumOfPaths := len(expected)
-	if numOfPaths != len(expected) {
-		t.Errorf("Expected number of paths to be %v but got %v", expectedNumOfPaths, numOfPaths)
+	if !slices.Equal(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
 	}
+}
 
-	builder.DeleteFolderAndSubFolders("output")
+func TestBuildPk3TrimShaders(t *testing.T) {
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"testmap",
+		"data/baseq3",
+		builder.Options{TrimShaders: true, OutputPath: t.TempDir()},
+	)
+	if err != nil {
+		t.Fatalf("BuildPk3WithOptions returned error %s", err)
+	}
+
+	readCloser, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		t.Fatalf("Open reader blew up: %s", err)
+	}
+	defer readCloser.Close()
+
+	scripts := []string{}
+	content := ""
+	for _, f := range readCloser.File {
+		if strings.HasPrefix(f.Name, "scripts/") && strings.HasSuffix(f.Name, ".shader") {
+			scripts = append(scripts, f.Name)
+		}
+		if f.Name == "scripts/testmap.shader" {
+			reader, err := f.Open()
+			if err != nil {
+				t.Fatalf("Failed opening %s: %s", f.Name, err)
+			}
+			data, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				t.Fatalf("Failed reading %s: %s", f.Name, err)
+			}
+			content = string(data)
+		}
+	}
+
+	if !slices.Equal(scripts, []string{"scripts/testmap.shader"}) {
+		t.Errorf("Expected only the generated shader file got %v", scripts)
+	}
+	for _, name := range []string{"textures/testmap/test_shader\n{", "textures/testmap/test_shader_2 {"} {
+		if strings.Count(content, name) != 1 {
+			t.Errorf("Expected %q once in generated shader file got %q", name, content)
+		}
+	}
+	if !strings.HasPrefix(content, "// Shaders used by testmap\n") {
+		t.Errorf("Expected header in generated shader file got %q", content)
+	}
 }
 
-func TestCreateDirectory(t *testing.T) {
-	expected := true
-	actual := builder.CreateDirectory("testcreate")
-	if actual != expected {
+func TestBuildPk3ExcludesStock(t *testing.T) {
+	tests := []struct {
+		options  builder.Options
+		expected []string
+	}{
+		{
+			builder.Options{},
+			[]string{"maps/stockmap.map", "textures/effects/custom_glow.jpg"},
+		},
+		{
+			builder.Options{StockArchives: []string{}},
+			[]string{
+				"maps/stockmap.map",
+				"scripts/common.shader",
+				"sound/world/dontinclude.wav",
+				"textures/effects/custom_glow.jpg",
+				"textures/gothic_block/blocks15.jpg",
+			},
+		},
+	}
+	for _, test := range tests {
+		test.options.OutputPath = t.TempDir()
+		pk3Path, err := builder.BuildPk3WithOptions("stockmap", "data/baseq3", test.options)
+		if err != nil {
+			t.Fatalf("BuildPk3WithOptions returned error %s", err)
+		}
+
+		readCloser, err := zip.OpenReader(pk3Path)
+		if err != nil {
+			t.Fatalf("Open reader blew up: %s", err)
+		}
+
+		actual := []string{}
+		for _, f := range readCloser.File {
+			if !strings.HasSuffix(f.Name, "/") {
+				actual = append(actual, f.Name)
+			}
+		}
+		readCloser.Close()
+		slices.Sort(actual)
+
+		if !slices.Equal(actual, test.expected) {
+			t.Errorf("Expected %v got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestBuildPk3FromArchives(t *testing.T) {
+	expected := []string{
+		"maps/packmap.map",
+		"scripts/evil8.shader",
+		"textures/evil8/wall.jpg",
+		"textures/evil8_fx/glow.tga",
+		"textures/testmap/test_texture.jpg",
+	}
+
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"packmap",
+		"data/baseq3",
+		builder.Options{OutputPath: t.TempDir()},
+	)
+	if err != nil {
+		t.Fatalf("BuildPk3 returned error %s", err)
+	}
+
+	readCloser, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		t.Fatalf("Open reader blew up: %s", err)
+	}
+	defer readCloser.Close()
+
+	actual := []string{}
+	for _, f := range readCloser.File {
+		if !strings.HasSuffix(f.Name, "/") {
+			actual = append(actual, f.Name)
+		}
+	}
+	slices.Sort(actual)
+	if !slices.Equal(actual, expected) {
 		t.Errorf("Expected %v got %v", expected, actual)
 	}
-	builder.DeleteFolderAndSubFolders("testcreate")
+
+	content, err := fs.ReadFile(readCloser, "textures/evil8/wall.jpg")
+	if err != nil || string(content) != "pack" {
+		t.Errorf("Expected textures/evil8/wall.jpg copied from evil8.pk3 got %q %v", content, err)
+	}
 }
 
-func TestZipOutputFolderAsPk3(t *testing.T) {
-	rootFolder := "output"
-	builder.CreateDirectory(rootFolder)
-	createSubFolder(rootFolder, "env")
-	createSubFolder(rootFolder, "maps")
-	createSubFolder(rootFolder, "textures")
-	createSubFolder(rootFolder+"/textures", "randomdir")
-	createSubFolder(rootFolder, "scripts")
-	createSubFolder(rootFolder, "sounds")
-	createSubFolder(rootFolder, "levelshots")
+func TestBuildPk3WithFsGame(t *testing.T) {
+	expected := []string{
+		"maps/modmap.map",
+		"textures/cpma/arena.tga",
+		"textures/testmap/test_texture.jpg",
+		"textures/testmap/test_texture_3.tga",
+	}
 
-	pk3Path, err := builder.ZipOutputFolderAsPk3("output", "testmap")
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"modmap",
+		"data/baseq3",
+		builder.Options{FsGame: "cpma", OutputPath: t.TempDir()},
+	)
 	if err != nil {
-		t.Errorf("Error while creating pk3: %s", err)
+		t.Fatalf("BuildPk3WithOptions returned error %s", err)
 	}
 
-	_, err = os.Stat(pk3Path)
+	readCloser, err := zip.OpenReader(pk3Path)
 	if err != nil {
-		t.Errorf("ZIP does not exist: %s", err)
+		t.Fatalf("Open reader blew up: %s", err)
+	}
+	defer readCloser.Close()
+
+	actual := []string{}
+	for _, f := range readCloser.File {
+		if !strings.HasSuffix(f.Name, "/") {
+			actual = append(actual, f.Name)
+		}
+	}
+	slices.Sort(actual)
+	if !slices.Equal(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	content, err := fs.ReadFile(readCloser, "textures/testmap/test_texture.jpg")
+	if err != nil || string(content) != "cpma override" {
+		t.Errorf("Expected the cpma texture to override baseq3 got %q %v", content, err)
+	}
+
+	pk3Path, err = builder.BuildPk3WithOptions(
+		"modmap",
+		"data/baseq3",
+		builder.Options{FsGame: "missing"},
+	)
+	if err == nil || pk3Path != "" {
+		t.Errorf("Expected an error for a missing mod directory got %s %v", pk3Path, err)
 	}
+}
 
-	_, err = os.Stat("output")
+func TestGameDirectories(t *testing.T) {
+	tests := []struct {
+		basePath string
+		fsGame   string
+		expected []string
+	}{
+		{"data/baseq3", "", []string{"data/baseq3"}},
+		{"data/baseq3", "baseq3", []string{"data/baseq3"}},
+		{"data/baseq3", "cpma", []string{"data/baseq3", filepath.Join("data", "cpma")}},
+		{"/games/quake3/baseq3/", "defrag", []string{"/games/quake3/baseq3/", "/games/quake3/defrag"}},
+	}
+	for _, test := range tests {
+		actual := builder.GameDirectories(test.basePath, test.fsGame)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %v got %v for %v", test.expected, actual, test)
+		}
+	}
+}
+
+func TestFindArchivedResources(t *testing.T) {
+	files, err := vfs.Open("data/baseq3")
+	if err != nil {
+		t.Fatalf("Open returned error %s", err)
+	}
+	defer files.Close()
+
+	resources := []string{
+		"textures/evil8_fx/glow.tga",
+		"textures/testmap/test_texture.jpg",
+		"scripts/evil8.shader",
+		"textures/missing.jpg",
+	}
+	expected := []builder.ArchivedResource{
+		{Resource: "scripts/evil8.shader", Archive: filepath.Join("data/baseq3", "evil8.pk3")},
+		{Resource: "textures/evil8_fx/glow.tga", Archive: filepath.Join("data/baseq3", "evil8.pk3")},
+	}
+	actual := builder.FindArchivedResources(files, resources)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestFilterStockResources(t *testing.T) {
+	files, err := vfs.Open("data/baseq3")
+	if err != nil {
+		t.Fatalf("Opening the vfs failed: %s", err)
+	}
+	defer files.Close()
+	stock := pak.Open(files, "data/baseq3", pak.DefaultArchives())
+
+	resources := []string{
+		"textures/gothic_block/blocks15.jpg",
+		"textures/effects/custom_glow.jpg",
+		"scripts/common.shader",
+		"scripts/testmap.shader",
+		"sound/world/dontinclude.wav",
+	}
+	expected := []string{"textures/effects/custom_glow.jpg", "scripts/testmap.shader"}
+	actual := builder.FilterStockResources(io.Discard, resources, stock)
+	if !slices.Equal(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestReadDependencies(t *testing.T) {
+	tests := []struct {
+		mapName        string
+		source         builder.Source
+		expectedSounds map[string]int
+		expectedErr    error
+	}{
+		{"testmap", builder.SourceAuto, map[string]int{"sound/testmap/sound-file.wav": 1}, nil},
+		{"testmap", builder.SourceMap, map[string]int{"sound/testmap/sound-file.wav": 1}, nil},
+		{"bsponly", builder.SourceAuto, map[string]int{"sound/testmap/sound-file.wav": 1}, nil},
+		{"bsponly", builder.SourceBsp, map[string]int{"sound/testmap/sound-file.wav": 1}, nil},
+		{"rbsponly", builder.SourceBsp, map[string]int{}, nil},
+		{"missing", builder.SourceAuto, map[string]int{}, builder.ErrMapNotFound},
+		{"bsponly", builder.SourceMap, map[string]int{}, builder.ErrMapNotFound},
+	}
+
+	for _, test := range tests {
+		_, actualSounds, _, _, _, err := builder.ReadDependencies(
+			test.mapName,
+			os.DirFS("data/baseq3"),
+			builder.Options{Source: test.source},
+		)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("Expected error %v got %v for %v", test.expectedErr, err, test)
+		}
+		if !reflect.DeepEqual(actualSounds, test.expectedSounds) {
+			t.Errorf("Expected %v got %v for %v", test.expectedSounds, actualSounds, test)
+		}
+	}
+
+	_, _, _, _, _, err := builder.ReadDependencies(
+		"testmap",
+		os.DirFS("data/baseq3"),
+		builder.Options{Source: builder.SourceBsp},
+	)
+	if err == nil {
+		t.Errorf("Expected error reading the empty testmap.bsp")
+	}
+}
+
+func TestVerifyBsp(t *testing.T) {
+	expected := builder.ShaderMismatch{
+		OnlyInMap: []string{"primitives/wall"},
+		OnlyInBsp: []string{"testmap/test_texture_3"},
+	}
+	actual, err := builder.VerifyBsp("drift", os.DirFS("data/baseq3"))
+	if err != nil {
+		t.Fatalf("VerifyBsp failed: %s", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	_, err = builder.VerifyBsp("bsponly", os.DirFS("data/baseq3"))
 	if err == nil {
-		t.Errorf("Output folder still exists %s", err)
+		t.Errorf("Expected error for map without .map source")
+	}
+
+	// Remap targets and runtime models never reach the bsp shader lump.
+	files := fstest.MapFS{}
+	for _, name := range []string{"maps/drift.map", "maps/drift.bsp", "models/mapobjects/gomaker/lamp.md3"} {
+		data, err := os.ReadFile(filepath.Join("data/baseq3", name))
+		if err != nil {
+			t.Fatalf("Reading fixture failed: %s", err)
+		}
+		files[name] = &fstest.MapFile{Data: data}
+	}
+	files["maps/drift.map"].Data = append(files["maps/drift.map"].Data, []byte(`
+{
+"classname" "func_static"
+"model" "models/mapobjects/gomaker/lamp.md3"
+"_remap" "*;testmap/remapped"
+}
+`)...)
+	actual, err = builder.VerifyBsp("drift", files)
+	if err != nil {
+		t.Fatalf("VerifyBsp failed: %s", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
 	}
 }
 
-func TestAddResourceIfExists(t *testing.T) {
+func TestCompareMaterials(t *testing.T) {
 	tests := []struct {
-		input    string
-		expected string
+		mapMaterials map[string]int
+		bspMaterials map[string]int
+		expected     builder.ShaderMismatch
 	}{
-		{"scripts/testmap.arena", "output/scripts/testmap.arena"},
-		{"levelshots/testmap.jpg", "output/levelshots/testmap.jpg"},
-		{"env/something/test.jpg", ""},
-		{"maps/testmap/lm_0000.tga", "output/maps/testmap/lm_0000.tga"},
+		{
+			map[string]int{"a/b": 1, "a/c": 2},
+			map[string]int{"a/c": 1, "a/b": 1},
+			builder.ShaderMismatch{OnlyInMap: []string{}, OnlyInBsp: []string{}},
+		},
+		{
+			map[string]int{"a/b": 1, "a/d": 1, "a/c": 1},
+			map[string]int{"a/e": 1},
+			builder.ShaderMismatch{OnlyInMap: []string{"a/b", "a/c", "a/d"}, OnlyInBsp: []string{"a/e"}},
+		},
+	}
+
+	for _, test := range tests {
+		actual := builder.CompareMaterials(test.mapMaterials, test.bspMaterials)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %v got %v", test.expected, actual)
+		}
+		if actual.HasMismatch() != (len(test.expected.OnlyInMap)+len(test.expected.OnlyInBsp) > 0) {
+			t.Errorf("Unexpected HasMismatch %v for %v", actual.HasMismatch(), actual)
+		}
+	}
+}
+
+func TestBuildPk3FailOnMismatch(t *testing.T) {
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"drift",
+		"data/baseq3",
+		builder.Options{FailOnMismatch: true},
+	)
+	if !errors.Is(err, builder.ErrShaderMismatch) || pk3Path != "" {
+		t.Errorf("Expected ErrShaderMismatch for mismatching map and bsp got %s %v", pk3Path, err)
+	}
+
+	pk3Path, err = builder.BuildPk3WithOptions(
+		"drift",
+		"data/baseq3",
+		builder.Options{Verify: true, OutputPath: t.TempDir()},
+	)
+	if err != nil || pk3Path == "" {
+		t.Errorf("Expected pk3 when only verifying got %v", err)
 	}
+}
 
+func TestBuildPk3Errors(t *testing.T) {
+	tests := []struct {
+		mapName  string
+		expected error
+	}{
+		{"missing", builder.ErrMapNotFound},
+		{"missingasset", builder.ErrMissingAsset},
+	}
 	for _, test := range tests {
+		outputPath := t.TempDir()
+		pk3Path, err := builder.BuildPk3WithOptions(
+			test.mapName,
+			"data/baseq3",
+			builder.Options{OutputPath: outputPath},
+		)
+		if !errors.Is(err, test.expected) || pk3Path != "" {
+			t.Errorf("Expected %v got %s %v for %s", test.expected, pk3Path, err, test.mapName)
+		}
+		_, err = os.Stat(filepath.Join(outputPath, test.mapName+".pk3"))
+		if err == nil {
+			t.Errorf("Expected no partial pk3 to be left behind for %s", test.mapName)
+		}
+	}
+}
+
+func TestPk3Path(t *testing.T) {
+	outputPath := t.TempDir()
+	tests := []struct {
+		basePath string
+		options  builder.Options
+		expected string
+	}{
+		{"data/baseq3", builder.Options{}, "testmap.pk3"},
+		{"data/baseq3", builder.Options{OutputPath: outputPath}, filepath.Join(outputPath, "testmap.pk3")},
+		{
+			"data/baseq3",
+			builder.Options{OutputPath: filepath.Join(outputPath, "nested", "custom.PK3")},
+			filepath.Join(outputPath, "nested", "custom.PK3"),
+		},
+		{"data/baseq3", builder.Options{OutputPath: outputPath, Install: true}, filepath.Join("data/baseq3", "testmap.pk3")},
+		{"data/baseq3", builder.Options{FsGame: "cpma", Install: true}, filepath.Join("data/cpma", "testmap.pk3")},
+	}
 
-		actual := builder.AddResourceIfExists("data/baseq3", test.input, "output")
+	for _, test := range tests {
+		actual, err := builder.Pk3Path("testmap", test.basePath, test.options)
+		if err != nil {
+			t.Errorf("Pk3Path returned error %s for %v", err, test.options)
+		}
 		if actual != test.expected {
-			t.Errorf("Expected %v got %v", test.expected, actual)
+			t.Errorf("Expected %s got %s for %v", test.expected, actual, test.options)
+		}
+	}
+
+	_, err := os.Stat(filepath.Join(outputPath, "nested"))
+	if err != nil {
+		t.Errorf("Expected the pk3 directory to be created: %s", err)
+	}
+}
+
+func TestBuildPk3InstallTwice(t *testing.T) {
+	basePath := filepath.Join(t.TempDir(), "baseq3")
+	err := os.CopyFS(basePath, os.DirFS("data/baseq3"))
+	if err != nil {
+		t.Fatalf("Copying the game directory failed: %s", err)
+	}
+
+	pk3Path, err := builder.BuildPk3WithOptions("stockmap", basePath, builder.Options{Install: true})
+	if err != nil {
+		t.Fatalf("BuildPk3WithOptions returned error %s", err)
+	}
+
+	// The pk3 installed by the first build must not stand in for the texture.
+	err = os.Remove(filepath.Join(basePath, "textures/effects/custom_glow.jpg"))
+	if err != nil {
+		t.Fatalf("Removing the texture failed: %s", err)
+	}
+	_, err = builder.BuildPk3WithOptions("stockmap", basePath, builder.Options{Install: true})
+	if err != nil {
+		t.Fatalf("BuildPk3WithOptions returned error %s on the second build", err)
+	}
+
+	readCloser, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		t.Fatalf("Open reader blew up: %s", err)
+	}
+	defer readCloser.Close()
+	actual := []string{}
+	for _, f := range readCloser.File {
+		if !strings.HasSuffix(f.Name, "/") {
+			actual = append(actual, f.Name)
+		}
+	}
+	if !slices.Equal(actual, []string{"maps/stockmap.map"}) {
+		t.Errorf("Expected [maps/stockmap.map] got %v", actual)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(basePath, ".*.tmp"))
+	if err != nil || len(leftovers) > 0 {
+		t.Errorf("Expected no temporary files got %v %v", leftovers, err)
+	}
+}
+
+func TestCreatePk3(t *testing.T) {
+	resources := []string{"scripts/testmap.arena", "levelshots/testmap.jpg", "maps/testmap.map"}
+	pk3Path, err := builder.CreatePk3(
+		os.DirFS("data/baseq3"),
+		resources,
+		filepath.Join(t.TempDir(), "testmap.pk3"),
+	)
+	if err != nil {
+		t.Fatalf("CreatePk3 returned error %s", err)
+	}
+
+	expected := []string{
+		"levelshots/testmap.jpg",
+		"maps/testmap.map",
+		"scripts/testmap.arena",
+	}
+
+	_, err = os.Stat(pk3Path)
+	if err != nil {
+		t.Errorf("PK3 does not exist: %s", err)
+	}
+
+	readCloser, err := zip.OpenReader(pk3Path)
+	if err != nil {
+		t.Errorf("Open reader blew up: %s", err)
+	}
+	defer readCloser.Close()
+
+	numOfPaths := len(readCloser.File)
+	for _, f := range readCloser.File {
+		path := f.Name
+		if !slices.Contains(expected, path) {
+			t.Errorf("Expected %s to be in %v", path, expected)
 		}
 	}
-	builder.DeleteFolderAndSubFolders("output")
+
+	expectedNumOfPaths := len(expected)
+	if numOfPaths != len(expected) {
+		t.Errorf("Expected number of paths to be %v but got %v", expectedNumOfPaths, numOfPaths)
+	}
+
+	_, err = builder.CreatePk3(
+		os.DirFS("data/baseq3"),
+		[]string{"maps/missing.map"},
+		filepath.Join(t.TempDir(), "testmap.pk3"),
+	)
+	if !errors.Is(err, builder.ErrMissingAsset) {
+		t.Errorf("Expected ErrMissingAsset got %v", err)
+	}
 }
 
-func TestDeleteFolderAndSubFolders(t *testing.T) {
-	builder.CreateDirectory("output")
-	createSubFolder("output", "testdelete")
-	builder.DeleteFolderAndSubFolders("output/testdelete")
+func TestWritePk3(t *testing.T) {
+	tests := []struct {
+		resources   []string
+		generated   map[string]string
+		expected    map[string]string
+		expectedErr error
+	}{
+		{
+			[]string{"testmap.txt", "cfg-maps/testmap.cfg", "testmap.txt"},
+			map[string]string{},
+			map[string]string{"testmap.txt": "", "cfg-maps/testmap.cfg": ""},
+			nil,
+		},
+		{
+			[]string{"scripts/testmap.shader"},
+			map[string]string{"scripts/testmap.shader": "// generated\n"},
+			map[string]string{"scripts/testmap.shader": "// generated\n"},
+			nil,
+		},
+		{[]string{"env/something/test.jpg"}, map[string]string{}, map[string]string{}, builder.ErrMissingAsset},
+	}
+
+	for _, test := range tests {
+		buffer := bytes.Buffer{}
+		err := builder.WritePk3(&buffer, os.DirFS("data/baseq3"), test.resources, test.generated)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("Expected error %v got %v for %v", test.expectedErr, err, test.resources)
+		}
+		if err != nil {
+			continue
+		}
+
+		reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+		if err != nil {
+			t.Fatalf("Reading pk3 failed: %s", err)
+		}
+		if len(reader.File) != len(test.expected) {
+			t.Errorf("Expected %d files got %d for %v", len(test.expected), len(reader.File), test.resources)
+		}
+		for _, f := range reader.File {
+			content, ok := test.expected[f.Name]
+			if !ok {
+				t.Errorf("Unexpected file %s in %v", f.Name, test.expected)
+				continue
+			}
+			if len(content) == 0 {
+				continue
+			}
+			actual, err := fs.ReadFile(reader, f.Name)
+			if err != nil || string(actual) != content {
+				t.Errorf("Expected %s to contain %q got %q %v", f.Name, content, actual, err)
+			}
+		}
+	}
 }
 
 func TestGetFile(t *testing.T) {
@@ -176,6 +713,7 @@ func TestGetFile(t *testing.T) {
 		expected string
 	}{
 		{"", ""},
+		{"missing.txt", ""},
 		{"testmap.txt", "testmap.txt"},
 		{"cfg-maps/testmap.cfg", "cfg-maps/testmap.cfg"},
 		{"maps/testmap.bsp", "maps/testmap.bsp"},
@@ -186,10 +724,18 @@ func TestGetFile(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		actual := builder.GetFile("data/baseq3", test.input)
+		actual, err := builder.GetFile(os.DirFS("data/baseq3"), test.input)
 		if actual != test.expected {
 			t.Errorf("Expected %s got %v", test.expected, actual)
 		}
+		if (err == nil) != (len(test.expected) > 0) {
+			t.Errorf("Unexpected error %v for %s", err, test.input)
+		}
+	}
+
+	_, err := builder.GetFile(os.DirFS("data/baseq3"), "missing.txt")
+	if !errors.Is(err, builder.ErrMissingAsset) {
+		t.Errorf("Expected ErrMissingAsset got %v", err)
 	}
 }
 
@@ -210,7 +756,10 @@ func TestGetExternalLightmaps(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		actualLightmaps := builder.GetExternalLightmaps("data/baseq3", test.input)
+		actualLightmaps, err := builder.GetExternalLightmaps(os.DirFS("data/baseq3"), test.input)
+		if err != nil {
+			t.Errorf("GetExternalLightmaps returned error %s", err)
+		}
 		actualLength := len(actualLightmaps)
 		expectedLength := len(test.expected)
 		if actualLength != expectedLength {
@@ -228,29 +777,470 @@ func TestGetExternalLightmaps(t *testing.T) {
 	}
 }
 
-func TestExtractFolderPaths(t *testing.T) {
+func TestDependencies(t *testing.T) {
+	contents, err := builder.Dependencies("packmap", "data/baseq3", builder.Options{})
+	if err != nil {
+		t.Fatalf("Dependencies returned error %s", err)
+	}
+	expected := []string{
+		"maps/packmap.map",
+		"scripts/evil8.shader",
+		"textures/evil8/wall.jpg",
+		"textures/evil8_fx/glow.tga",
+		"textures/testmap/test_texture.jpg",
+	}
+	if !slices.Equal(contents.Resources, expected) {
+		t.Errorf("Expected %v got %v", expected, contents.Resources)
+	}
+	if len(contents.Archived) != 3 {
+		t.Errorf("Expected every evil8 file to come from the pack got %v", contents.Archived)
+	}
+
+	_, err = builder.Dependencies("missing", "data/baseq3", builder.Options{})
+	if !errors.Is(err, builder.ErrMapNotFound) {
+		t.Errorf("Expected ErrMapNotFound got %v", err)
+	}
+}
+
+func TestApplyPatterns(t *testing.T) {
+	resources := []string{"maps/testmap.map", "maps/testmap.bsp", "textures/testmap/test_texture.jpg"}
 	tests := []struct {
-		input    string
-		expected string
+		include        []string
+		exclude        []string
+		expected       []string
+		expectedReport builder.PatternReport
+	}{
+		{
+			nil,
+			nil,
+			[]string{"maps/testmap.bsp", "maps/testmap.map", "textures/testmap/test_texture.jpg"},
+			builder.NewPatternReport(),
+		},
+		{
+			nil,
+			[]string{"maps/*.map", "env/**"},
+			[]string{"maps/testmap.bsp", "textures/testmap/test_texture.jpg"},
+			builder.PatternReport{
+				Included:  []builder.PatternMatch{},
+				Excluded:  []builder.PatternMatch{{Resource: "maps/testmap.map", Pattern: "maps/*.map"}},
+				Unmatched: []string{"env/**"},
+			},
+		},
+		{
+			[]string{"levelshots/*", "maps/testmap.bsp"},
+			nil,
+			[]string{
+				"levelshots/testmap.jpg",
+				"levelshots/testmap2.tga",
+				"maps/testmap.bsp",
+				"maps/testmap.map",
+				"textures/testmap/test_texture.jpg",
+			},
+			builder.PatternReport{
+				Included: []builder.PatternMatch{
+					{Resource: "levelshots/testmap.jpg", Pattern: "levelshots/*"},
+					{Resource: "levelshots/testmap2.tga", Pattern: "levelshots/*"},
+				},
+				Excluded:  []builder.PatternMatch{},
+				Unmatched: []string{},
+			},
+		},
+		{
+			[]string{"levelshots", "maps/**/lm_0001.tga"},
+			[]string{"**/*.tga", "textures"},
+			[]string{"levelshots/testmap.jpg", "maps/testmap.bsp", "maps/testmap.map"},
+			builder.PatternReport{
+				Included: []builder.PatternMatch{{Resource: "levelshots/testmap.jpg", Pattern: "levelshots"}},
+				Excluded: []builder.PatternMatch{
+					{Resource: "levelshots/testmap2.tga", Pattern: "**/*.tga"},
+					{Resource: "maps/testmap/lm_0001.tga", Pattern: "**/*.tga"},
+					{Resource: "textures/testmap/test_texture.jpg", Pattern: "textures"},
+				},
+				Unmatched: []string{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		actual, report, err := builder.ApplyPatterns(
+			os.DirFS("data/baseq3"),
+			resources,
+			test.include,
+			test.exclude,
+		)
+		if err != nil {
+			t.Errorf("ApplyPatterns returned error %s", err)
+		}
+		if !slices.Equal(actual, test.expected) {
+			t.Errorf("Expected %v got %v for %v %v", test.expected, actual, test.include, test.exclude)
+		}
+		if !reflect.DeepEqual(report, test.expectedReport) {
+			t.Errorf("Expected report %v got %v for %v %v", test.expectedReport, report, test.include, test.exclude)
+		}
+	}
+
+	for _, pattern := range []string{"maps/[", ""} {
+		_, _, err := builder.ApplyPatterns(os.DirFS("data/baseq3"), resources, nil, []string{pattern})
+		if !errors.Is(err, path.ErrBadPattern) {
+			t.Errorf("Expected ErrBadPattern for %q got %v", pattern, err)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
 	}{
-		{"test", "test"},
-		{"this/is/a/test", "this/is/a/test"},
-		{"this/is/also/a/test.txt", "this/is/also/a"},
-		{"data/baseq3/scripts/testmap.arena", "data/baseq3/scripts"},
+		{"maps/*.map", "maps/testmap.map", true},
+		{"maps/*.map", "maps/testmap/lm_0000.tga", false},
+		{"*.txt", "readme.txt", true},
+		{"*.txt", "docs/readme.txt", false},
+		{"**/*.txt", "docs/readme.txt", true},
+		{"**/*.txt", "readme.txt", true},
+		{"env/**", "env/sky/space_rt.tga", true},
+		{"env/**/space_??.tga", "env/sky/space_rt.tga", true},
+		{"textures/**/glow.tga", "textures/glow.tga", true},
+		{"textures/**/glow.tga", "textures/evil8_fx/glow.jpg", false},
 	}
 
 	for _, test := range tests {
-		actual := builder.ExtractFolderPaths(test.input)
+		actual := builder.MatchPattern(test.pattern, test.name)
 		if actual != test.expected {
-			t.Errorf("Expected %s got %v", test.expected, actual)
+			t.Errorf("Expected %v got %v for %s %s", test.expected, actual, test.pattern, test.name)
 		}
 	}
 }
 
-func createSubFolder(rootFolder string, folderName string) {
-	path := fmt.Sprintf("%s/%s", rootFolder, folderName)
-	err := os.Mkdir(path, 0777)
+func TestBuildPk3PatternReport(t *testing.T) {
+	contents, err := builder.Dependencies(
+		"testmap",
+		"data/baseq3",
+		builder.Options{
+			TrimShaders: true,
+			Include:     []string{"maps/testmap.map", "scripts/testmap.arena"},
+			Exclude:     []string{"scripts/*.shader", "maps/testmap"},
+		},
+	)
 	if err != nil {
-		fmt.Printf("createSubFolder for path %s failed with err: %s", path, err)
+		t.Fatalf("Dependencies returned error %s", err)
+	}
+	expected := builder.PatternReport{
+		Included: []builder.PatternMatch{},
+		Excluded: []builder.PatternMatch{
+			{Resource: "maps/testmap/lm_0000.tga", Pattern: "maps/testmap"},
+			{Resource: "maps/testmap/lm_0001.tga", Pattern: "maps/testmap"},
+			{Resource: "maps/testmap/lm_0002.tga", Pattern: "maps/testmap"},
+			{Resource: "scripts/testmap.shader", Pattern: "scripts/*.shader"},
+		},
+		Unmatched: []string{},
+	}
+	if !reflect.DeepEqual(contents.Patterns, expected) {
+		t.Errorf("Expected %v got %v", expected, contents.Patterns)
+	}
+	if len(contents.Generated) != 0 {
+		t.Errorf("Expected the generated shader file to be excluded got %v", contents.Generated)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		mapName          string
+		expectedCompared bool
+		expectedMismatch bool
+	}{
+		{"drift", true, true},
+		{"bsponly", false, false},
+		{"stockmap", false, false},
+	}
+
+	for _, test := range tests {
+		report, err := builder.Check(test.mapName, "data/baseq3", builder.Options{})
+		if err != nil {
+			t.Errorf("Check returned error %s for %s", err, test.mapName)
+		}
+		if report.Compared != test.expectedCompared {
+			t.Errorf("Expected compared %v got %v for %s", test.expectedCompared, report.Compared, test.mapName)
+		}
+		if report.Mismatch.HasMismatch() != test.expectedMismatch {
+			t.Errorf("Expected mismatch %v got %v for %s", test.expectedMismatch, report.Mismatch, test.mapName)
+		}
+	}
+
+	_, err := builder.Check("missingasset", "data/baseq3", builder.Options{})
+	if !errors.Is(err, builder.ErrMissingAsset) {
+		t.Errorf("Expected ErrMissingAsset got %v", err)
+	}
+
+	_, err = builder.Check("testmap", "data/baseq3", builder.Options{})
+	if err == nil {
+		t.Errorf("Expected an error comparing against the empty testmap.bsp")
+	}
+}
+
+func TestInspectAndDiffPk3(t *testing.T) {
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"packmap",
+		"data/baseq3",
+		builder.Options{OutputPath: t.TempDir()},
+	)
+	if err != nil {
+		t.Fatalf("BuildPk3WithOptions returned error %s", err)
+	}
+
+	entries, err := builder.InspectPk3(pk3Path)
+	if err != nil {
+		t.Fatalf("InspectPk3 returned error %s", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	expected := []string{
+		"maps/packmap.map",
+		"scripts/evil8.shader",
+		"textures/evil8/wall.jpg",
+		"textures/evil8_fx/glow.tga",
+		"textures/testmap/test_texture.jpg",
+	}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected %v got %v", expected, names)
+	}
+	if entries[2].Size != uint64(len("pack")) {
+		t.Errorf("Expected wall.jpg to be %d bytes got %d", len("pack"), entries[2].Size)
+	}
+
+	contents := builder.Contents{
+		Resources: []string{"maps/packmap.map", "TEXTURES/evil8/wall.jpg", "textures/evil8/missing.jpg"},
+		Generated: map[string]string{"scripts/packmap.shader": ""},
+	}
+	diff := builder.DiffPk3(contents, entries)
+	expectedDiff := builder.Pk3Diff{
+		Missing: []string{"scripts/packmap.shader", "textures/evil8/missing.jpg"},
+		Extra: []string{
+			"scripts/evil8.shader",
+			"textures/evil8_fx/glow.tga",
+			"textures/testmap/test_texture.jpg",
+		},
+	}
+	if !reflect.DeepEqual(diff, expectedDiff) {
+		t.Errorf("Expected %v got %v", expectedDiff, diff)
+	}
+
+	_, err = builder.InspectPk3("data/baseq3/missing.pk3")
+	if err == nil {
+		t.Errorf("Expected an error inspecting a missing pk3")
+	}
+}
+
+func TestBuildPk3WithConfig(t *testing.T) {
+	outputPath := t.TempDir()
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"configmap",
+		"data/baseq3",
+		builder.Options{OutputPath: outputPath},
+	)
+	if err != nil {
+		t.Fatalf("BuildPk3WithOptions returned error %s", err)
+	}
+	if pk3Path != filepath.Join(outputPath, "configmap-v2.pk3") {
+		t.Errorf("Expected the pk3 name from the config got %s", pk3Path)
+	}
+
+	entries, err := builder.InspectPk3(pk3Path)
+	if err != nil {
+		t.Fatalf("InspectPk3 returned error %s", err)
+	}
+	actual := []string{}
+	for _, entry := range entries {
+		actual = append(actual, entry.Name)
+	}
+	expected := []string{
+		"levelshots/testmap.jpg",
+		"textures/effects/custom_glow.jpg",
+		"textures/testmap/test_texture.jpg",
+	}
+	if !slices.Equal(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	_, err = builder.BuildPk3WithOptions(
+		"configmap",
+		"data/baseq3",
+		builder.Options{OutputPath: outputPath, ConfigPath: "data/configs/othermap.json"},
+	)
+	if !errors.Is(err, config.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a config of another map got %v", err)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	mapConfig := config.Config{
+		FsGame:      "cpma",
+		Pk3Name:     "custom",
+		Source:      "bsp",
+		Include:     []string{"env/*"},
+		Exclude:     []string{"maps/*.map"},
+		TrimShaders: true,
+	}
+	tests := []struct {
+		options  builder.Options
+		expected builder.Options
+	}{
+		{
+			builder.Options{},
+			builder.Options{
+				FsGame:      "cpma",
+				Pk3Name:     "custom",
+				Source:      builder.SourceBsp,
+				Include:     []string{"env/*"},
+				Exclude:     []string{"maps/*.map"},
+				TrimShaders: true,
+			},
+		},
+		{
+			builder.Options{FsGame: "defrag", Source: builder.SourceMap, Exclude: []string{"*.txt"}},
+			builder.Options{
+				FsGame:      "defrag",
+				Pk3Name:     "custom",
+				Source:      builder.SourceMap,
+				Include:     []string{"env/*"},
+				Exclude:     []string{"maps/*.map", "*.txt"},
+				TrimShaders: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := builder.ApplyConfig(test.options, mapConfig)
+		if err != nil {
+			t.Errorf("ApplyConfig returned error %s", err)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %v got %v", test.expected, actual)
+		}
+	}
+
+	_, err := builder.ApplyConfig(builder.Options{}, config.Config{Source: "both"})
+	if !errors.Is(err, config.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for an unknown source got %v", err)
+	}
+}
+
+func TestBuildPk3WithMD3(t *testing.T) {
+	contents, err := builder.Dependencies("md3map", "data/baseq3", builder.Options{})
+	if err != nil {
+		t.Fatalf("Dependencies returned error %s", err)
+	}
+	expected := []string{
+		"maps/md3map.map",
+		"models/mapobjects/gomaker/barrel.obj",
+		"models/mapobjects/gomaker/barrel_ao.tga",
+		"models/mapobjects/gomaker/barrel_body.mtl",
+		"models/mapobjects/gomaker/barrel_body.tga",
+		"models/mapobjects/gomaker/barrel_body_n.tga",
+		"models/mapobjects/gomaker/barrel_decals.mtl",
+		"models/mapobjects/gomaker/barrel_trim.mtl",
+		"models/mapobjects/gomaker/decal_alpha.tga",
+		"models/mapobjects/gomaker/flag.mdr",
+		"models/mapobjects/gomaker/flag.tga",
+		"models/mapobjects/gomaker/lamp.md3",
+		"models/mapobjects/gomaker/lamp.tga",
+		"models/mapobjects/gomaker/lamp_1.md3",
+		"models/mapobjects/gomaker/lamp_default.skin",
+		"models/mapobjects/gomaker/lamp_skin.tga",
+		"models/mapobjects/gomaker/robot.iqm",
+		"models/mapobjects/gomaker/robot.tga",
+		"scripts/testmap.shader",
+		"textures/testmap/test_model_texture_2.tga",
+		"textures/testmap/test_shader_2.tga",
+		"textures/testmap/test_shader_3.jpg",
+		"textures/testmap/test_texture.jpg",
+	}
+	if !slices.Equal(contents.Resources, expected) {
+		t.Errorf("Expected %v got %v", expected, contents.Resources)
+	}
+}
+
+func TestBuildPk3WritesProgressToOutput(t *testing.T) {
+	output := &bytes.Buffer{}
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"stockmap",
+		"data/baseq3",
+		builder.Options{OutputPath: t.TempDir(), Output: output},
+	)
+	if err != nil {
+		t.Fatalf("BuildPk3WithOptions returned error %s", err)
+	}
+	for _, expected := range []string{"Skipping scripts/common.shader", "Created pk3 " + pk3Path} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in output got %q", expected, output.String())
+		}
+	}
+}
+
+func TestLoadOptionsResolvesOutputPath(t *testing.T) {
+	configDirectory := t.TempDir()
+	configPath := filepath.Join(configDirectory, "configmap.json")
+	err := os.WriteFile(configPath, []byte(`{"map": "configmap", "outputPath": "build"}`), 0644)
+	if err != nil {
+		t.Fatalf("Writing the config failed: %s", err)
+	}
+
+	options, err := builder.LoadOptions(
+		"configmap",
+		"data/baseq3",
+		builder.Options{ConfigPath: configPath, Output: io.Discard},
+	)
+	if err != nil {
+		t.Fatalf("LoadOptions returned error %s", err)
+	}
+	expected := filepath.Join(configDirectory, "build")
+	if options.OutputPath != expected {
+		t.Errorf("Expected %s got %s", expected, options.OutputPath)
+	}
+}
+
+func TestBuildPk3WithoutStockArchives(t *testing.T) {
+	basePath := filepath.Join(t.TempDir(), "baseq3")
+	err := os.CopyFS(basePath, os.DirFS("data/baseq3"))
+	if err != nil {
+		t.Fatalf("Copying the game directory failed: %s", err)
+	}
+	err = os.Remove(filepath.Join(basePath, "pak0.pk3"))
+	if err != nil {
+		t.Fatalf("Removing pak0.pk3 failed: %s", err)
+	}
+
+	pk3Path, err := builder.BuildPk3WithOptions(
+		"testmap",
+		basePath,
+		builder.Options{OutputPath: t.TempDir(), Output: io.Discard},
+	)
+	if err != nil {
+		t.Fatalf("BuildPk3WithOptions returned error %s", err)
+	}
+	entries, err := builder.InspectPk3(pk3Path)
+	if err != nil {
+		t.Fatalf("InspectPk3 returned error %s", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name, "sound/world/") {
+			t.Errorf("Expected no stock sounds without pak0.pk3 got %s", entry.Name)
+		}
+	}
+}
+
+func TestCheckResources(t *testing.T) {
+	files := os.DirFS("data/baseq3")
+	err := builder.CheckResources(files, []string{"maps/testmap.map", "sound/testmap/sound-file.wav"})
+	if err != nil {
+		t.Errorf("CheckResources returned error %s", err)
+	}
+
+	err = builder.CheckResources(files, []string{"maps/testmap.map", "models/mapobjects/gomaker/missing.skin"})
+	if !errors.Is(err, builder.ErrMissingAsset) {
+		t.Errorf("Expected %v got %v", builder.ErrMissingAsset, err)
 	}
 }
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gomaker/internal/config"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    config.Config
+		expectedErr error
+	}{
+		{
+			"data/baseq3/maps/configmap.gomaker.json",
+			config.Config{
+				Map:     "configmap",
+				Pk3Name: "configmap-v2",
+				Source:  "map",
+				Include: []string{"levelshots/testmap.jpg"},
+				Exclude: []string{"maps/*.map"},
+			},
+			nil,
+		},
+		{"data/configs/othermap.json", config.Config{Map: "testmap", BasePath: "../baseq3"}, nil},
+		{"data/configs/typo.json", config.Config{}, config.ErrInvalidConfig},
+	}
+
+	for _, test := range tests {
+		actual, err := config.Read(test.input)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("Expected error %v got %v for %s", test.expectedErr, err, test.input)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %v got %v for %s", test.expected, actual, test.input)
+		}
+	}
+
+	_, err := config.Read("data/configs/missing.json")
+	if err == nil {
+		t.Errorf("Expected an error reading a missing config")
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	tests := []struct {
+		directories  []string
+		mapName      string
+		expected     string
+		expectedBool bool
+	}{
+		{
+			[]string{"data/baseq3"},
+			"configmap",
+			filepath.Join("data/baseq3", "maps", "configmap.gomaker.json"),
+			true,
+		},
+		{
+			[]string{"data/baseq3", "data/cpma"},
+			"configmap",
+			filepath.Join("data/baseq3", "maps", "configmap.gomaker.json"),
+			true,
+		},
+		{[]string{"data/baseq3"}, "testmap", "", false},
+	}
+
+	for _, test := range tests {
+		actual, ok := config.Find(test.directories, test.mapName)
+		if actual != test.expected || ok != test.expectedBool {
+			t.Errorf(
+				"Expected %s %v got %s %v for %s",
+				test.expected,
+				test.expectedBool,
+				actual,
+				ok,
+				test.mapName,
+			)
+		}
+	}
+}
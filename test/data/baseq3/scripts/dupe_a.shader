@@ -0,0 +1,6 @@
+textures/dupe/shader
+{
+	{
+		map textures/dupe/from_a.tga
+	}
+}
@@ -0,0 +1,15 @@
+// Overrides the definition in dupe_a.shader when every file is loaded
+
+textures/dupe/shader
+{
+	{
+		map textures/dupe/from_b.tga
+	}
+}
+
+textures/dupe/shader
+{
+	{
+		map textures/dupe/from_b_second.tga
+	}
+}
@@ -0,0 +1,34 @@
+textures/remap/base
+{
+	q3map_remapShader textures/remap/target
+	q3map_lightImage textures/remap/light.tga
+	q3map_cloneShader textures/remap/missing
+	{
+		map textures/remap/base_tex.tga
+	}
+}
+
+textures/remap/target
+{
+	// Points back at base, the resolver must not loop forever
+	q3map_baseShader textures/remap/base
+	q3map_backShader textures/remap/back
+	{
+		map textures/remap/target_tex.tga
+	}
+}
+
+textures/remap/back
+{
+	cull back
+	{
+		map textures/remap/back_tex.tga
+	}
+}
+
+textures/remap/unused
+{
+	{
+		map textures/remap/unused_tex.tga
+	}
+}
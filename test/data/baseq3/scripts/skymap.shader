@@ -0,0 +1,18 @@
+textures/skymap/space
+{
+	qer_editorimage textures/skymap/space_qer.tga
+	surfaceparm noimpact
+	surfaceparm nolightmap
+	surfaceparm sky
+	skyParms env/skymap/space 512 -
+}
+
+textures/skymap/anim
+{
+	surfaceparm nolightmap
+	{
+		animMap 5 textures/skymap/anim_1.tga textures/skymap/anim_2.tga textures/skymap/anim_3.tga
+		blendFunc GL_ONE GL_ONE
+		rgbGen wave inverseSawtooth 0 1 0 5
+	}
+}
@@ -1,119 +1,83 @@
 package test
 
 import (
+	"errors"
+	"os"
 	"reflect"
 	"testing"
 
 	"gomaker/internal/entity"
+	"gomaker/internal/material"
 )
 
-func TestIsEntity(t *testing.T) {
+func TestParseKeyValues(t *testing.T) {
 	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{
-			"( 104 400 176 ) ( 112 400 192 ) ( 104 272 176 ) common/caulk 32 0 0 0.5 0.5 134217728 0 0",
-			false,
-		},
-		{"// Entity 0", true},
-		{"// Brush 1337", false},
-		{
-			"( 96 80 192 ) ( 240 80 128 ) ( 240 80 192 ) testmap/test_texture 461.2879333496 22.0878295898 -26.5999984741 0.2808699906 0.280872494 134217728 0 0",
-			false,
-		},
-		{"// entity 1", true},
-		{"// brush 0", false},
-	}
-	for _, test := range tests {
-		value := entity.IsEntity(test.input)
-		if value != test.expected {
-			t.Errorf("Expected %v got %v for %v", test.expected, value, test)
-		}
-	}
-}
-
-func TestParseEntity(t *testing.T) {
-	tests := []struct {
-		input    []string
+		input    map[string]string
 		expected map[string]int
 	}{
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"origin" "-924 -4 536"`,
-			`"model" "data/baseq3/models/test-model.ase"`,
-			`"angles" "-0 0 -180"`,
-			"}",
+		{map[string]string{
+			"classname": "misc_model",
+			"origin":    "-924 -4 536",
+			"model":     "models/test-model.ase",
 		}, map[string]int{"testmap/test_model_texture_1": 1}},
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"origin" "-924 -4 536"`,
-			`"model" "data/baseq3/models/test-model-2.ase"`,
-			`"angles" "-0 0 -180"`,
-			"}",
-		}, map[string]int{"texture_test/concrete_tile": 1, "texture_test/texture-2": 1}},
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"model" "data/baseq3/models/test-material.obj"`,
-			"}",
+		{map[string]string{
+			"classname": "misc_model",
+			"model":     "models/test-material.obj",
 		}, map[string]int{"testmap/test_model_texture_2": 1}},
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"model" "data/baseq3/models/test-material-2.obj"`,
-			"}",
-		}, map[string]int{"texture_test/concrete_tile": 1}},
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"model" "data/baseq3/models/test-material-2.obj"`,
-			`"_remap" ":*;textures/test_texture/texture-2"`,
-			"}",
-		}, map[string]int{"test_texture/texture-2": 1}},
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"origin" "-924 -4 536"`,
-			`"model" "maps/models/test-model.ase"`,
-			`"angles" "-0 0 -180"`,
-			`"_remap" "*;textures/testmap/test_texture"`,
-			"}",
-		}, map[string]int{"testmap/test_texture": 1}},
-		{[]string{
-			"{",
-			`"classname" "worldspawn"`,
-			`"message" "Test map"`,
-			`"ambient" "10"`,
-			"}",
+		{map[string]string{
+			"classname": "misc_model",
+			"model":     "models/test-model.ase",
+			"_remap":    "*;textures/testmap/test_texture",
+			"_remap2":   "old/shader;textures/testmap/test_texture_3",
+		}, map[string]int{"testmap/test_texture": 1, "testmap/test_texture_3": 1}},
+		{map[string]string{
+			"classname": "func_static",
+			"model":     "*2",
 		}, map[string]int{}},
-		{[]string{"{", "}"}, map[string]int{}},
+		{map[string]string{
+			"classname":  "misc_model",
+			"spawnflags": "2",
+			"model":      "models/test-material.obj",
+		}, map[string]int{"testmap/test_model_texture_2": 1}},
+		{map[string]string{
+			"classname": "misc_model",
+			"model":     "models/mapobjects/gomaker/lamp.md3",
+		}, map[string]int{"models/mapobjects/gomaker/lamp": 1, "testmap/test_shader": 1}},
+		{map[string]string{
+			"classname": "func_bobbing",
+			"model":     "*1",
+			"model2":    `\models\mapobjects\gomaker\lamp.md3`,
+		}, map[string]int{
+			"models/mapobjects/gomaker/lamp":      1,
+			"models/mapobjects/gomaker/lamp_skin": 1,
+			"testmap/test_shader":                 2,
+		}},
+		{map[string]string{
+			"classname": "func_rotating",
+			"model2":    "models/mapobjects/gomaker/flag.mdr",
+		}, map[string]int{"models/mapobjects/gomaker/flag": 1, "testmap/test_shader": 1}},
+		{map[string]string{
+			"classname": "func_bobbing",
+			"model2":    "models/mapobjects/gomaker/robot.iqm",
+		}, map[string]int{"models/mapobjects/gomaker/robot": 1, "testmap/test_shader": 1}},
+		{map[string]string{"classname": "worldspawn", "message": "Test map"}, map[string]int{}},
 	}
 	for _, test := range tests {
-		actual := entity.ParseEntity(test.input)
+		actual, err := entity.ParseKeyValues(test.input, os.DirFS("data/baseq3"))
+		if err != nil {
+			t.Errorf("ParseKeyValues returned error %s for %v", err, test.input)
+		}
 		if !reflect.DeepEqual(actual, test.expected) {
 			t.Errorf("Expected %v got %v for %v", test.expected, actual, test.input)
 		}
 	}
-}
 
-func TestModelPath(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{`"model" "data/baseq3/models/test-model.ase"`, "data/baseq3/models/test-model.ase"},
-		{`"model" "data/baseq3/models/test-model-2.ase"`, "data/baseq3/models/test-model-2.ase"},
-		{`"model" "data/baseq3/models/test-model-3.obj"`, "data/baseq3/models/test-model-3.obj"},
-		{`"model" "maps/models/test-model.ase"`, "maps/models/test-model.ase"},
-	}
-	for _, test := range tests {
-		actual := entity.ModelPath(test.input)
-		if actual != test.expected {
-			t.Errorf("Expected %s got %s for %v", test.expected, actual, test)
-		}
+	_, err := entity.ParseKeyValues(
+		map[string]string{"classname": "misc_model", "model": "models/missing.ase"},
+		os.DirFS("data/baseq3"),
+	)
+	if !errors.Is(err, material.ErrMissingAsset) {
+		t.Errorf("Expected ErrMissingAsset got %v", err)
 	}
 }
 
@@ -142,56 +106,136 @@ func TestParseModel(t *testing.T) {
 		path     string
 		expected map[string]int
 	}{
-		{"data/baseq3/models/test-model.ase", map[string]int{"testmap/test_model_texture_1": 1}},
+		{"models/test-model.ase", map[string]int{"testmap/test_model_texture_1": 1}},
+		{
+			"models/test-model-2.ase",
+			map[string]int{
+				"common/caulk":               1,
+				"texture_test/concrete_tile": 1,
+				"texture_test/texture-2":     1,
+			},
+		},
+		{"models/test-material.mtl", map[string]int{"testmap/test_model_texture_2": 1}},
+		{"models/test-material-2.mtl", map[string]int{"texture_test/concrete_tile": 1}},
+		{
+			"models/mapobjects/gomaker/crate.ase",
+			map[string]int{
+				"models/mapobjects/gomaker/crate_side": 1,
+				"testmap/test_shader":                  1,
+				"testmap/test_texture":                 1,
+			},
+		},
 		{
-			"data/baseq3/models/test-model-2.ase",
-			map[string]int{"texture_test/concrete_tile": 1, "texture_test/texture-2": 1},
+			"models/mapobjects/gomaker/barrel.obj",
+			map[string]int{
+				"models/mapobjects/gomaker/barrel_ao":     1,
+				"models/mapobjects/gomaker/barrel_body":   1,
+				"models/mapobjects/gomaker/barrel_body_n": 1,
+				"models/mapobjects/gomaker/decal_alpha":   1,
+				"testmap/test_shader":                     1,
+				"testmap/test_texture":                    1,
+			},
 		},
-		{"data/baseq3/models/test-material.mtl", map[string]int{"testmap/test_model_texture_2": 1}},
-		{"data/baseq3/models/test-material-2.mtl", map[string]int{"texture_test/concrete_tile": 1}},
 	}
 	for _, test := range tests {
-		actual := entity.ParseModel(test.path)
+		actual, err := entity.ParseModel(test.path, os.DirFS("data/baseq3"))
+		if err != nil {
+			t.Errorf("ParseModel returned error %s for %s", err, test.path)
+		}
 		if !reflect.DeepEqual(actual, test.expected) {
 			t.Errorf("Expected %v got %v", test.expected, actual)
 		}
 	}
 }
 
-func TestObjTexture(t *testing.T) {
+func TestRuntimeModels(t *testing.T) {
 	tests := []struct {
-		path     string
-		expected string
+		input    map[string]string
+		expected []string
 	}{
+		{map[string]string{"classname": "misc_model", "model": "models/a.md3"}, []string{}},
+		{map[string]string{"classname": "misc_model", "model": "models/a.md3", "spawnflags": "0"}, []string{}},
+		{map[string]string{"classname": "misc_model", "model": "models/a.obj", "spawnflags": "4"}, []string{}},
+		{map[string]string{"classname": "func_door", "model": "*3"}, []string{}},
 		{
-			`map_Kd /long/path/for/some/reason/textures/testmap/test_texture.jpg`,
-			"testmap/test_texture",
+			map[string]string{"classname": "func_bobbing", "model": "*1", "model2": `models\b.MD3`},
+			[]string{"models/b.MD3"},
 		},
 		{
-			`map_Kd \slash\wrong\way\textures\texture_test\concrete_tile.jpg`,
-			"texture_test/concrete_tile",
+			map[string]string{"classname": "misc_model", "model": "/models/a.md3", "model2": "models/a.md3"},
+			[]string{"models/a.md3"},
 		},
+		{map[string]string{"classname": "target_position", "model": "models/c.md3"}, []string{"models/c.md3"}},
+		{map[string]string{"classname": "func_static", "model2": "models/d.ase"}, []string{"models/d.ase"}},
 	}
+
 	for _, test := range tests {
-		actual := entity.ObjTexture(test.path)
-		if actual != test.expected {
-			t.Errorf("Expected %s got %s for %v", test.expected, actual, test)
+		actual := entity.RuntimeModels(test.input)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %v got %v for %v", test.expected, actual, test.input)
 		}
 	}
 }
 
-func TestAseTexture(t *testing.T) {
+func TestModelCompanions(t *testing.T) {
 	tests := []struct {
-		path     string
-		expected string
+		path      string
+		keyValues map[string]string
+		expected  []string
 	}{
-		{`*BITMAP "//../textures/testmap/test_texture.jpg"`, "testmap/test_texture"},
-		{`*BITMAP	"..\textures\texture_test\concrete_tile.tga"`, "texture_test/concrete_tile"},
+		{
+			"models/mapobjects/gomaker/lamp.md3",
+			map[string]string{},
+			[]string{"models/mapobjects/gomaker/lamp_default.skin", "models/mapobjects/gomaker/lamp_1.md3"},
+		},
+		{
+			"models/mapobjects/gomaker/lamp.md3",
+			map[string]string{"skin": "2"},
+			[]string{
+				"models/mapobjects/gomaker/lamp_default.skin",
+				"models/mapobjects/gomaker/lamp_2.skin",
+				"models/mapobjects/gomaker/lamp_1.md3",
+			},
+		},
+		{
+			"models/mapobjects/gomaker/lamp.md3",
+			map[string]string{"_skin": `models\mapobjects\gomaker\LAMP_POST_RED.skin`},
+			[]string{
+				"models/mapobjects/gomaker/lamp_default.skin",
+				"models/mapobjects/gomaker/lamp_post_red.skin",
+				"models/mapobjects/gomaker/lamp_1.md3",
+			},
+		},
+		{"models/test-material.obj", map[string]string{}, []string{"models/test-material.mtl"}},
+		{
+			"models/mapobjects/gomaker/barrel.obj",
+			map[string]string{},
+			[]string{
+				"models/mapobjects/gomaker/barrel_body.mtl",
+				"models/mapobjects/gomaker/barrel_trim.mtl",
+				"models/mapobjects/gomaker/barrel_decals.mtl",
+			},
+		},
+		{"models/missing/model.md3", map[string]string{"skin": "3"}, []string{}},
 	}
 	for _, test := range tests {
-		actual := entity.AseTexture(test.path)
-		if actual != test.expected {
-			t.Errorf("Expected %s got %s for %v", test.expected, actual, test)
+		actual, err := entity.ModelCompanions(test.path, test.keyValues, os.DirFS("data/baseq3"))
+		if err != nil {
+			t.Errorf("ModelCompanions returned error %s for %s", err, test.path)
 		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %v got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestParseSkin(t *testing.T) {
+	actual, err := entity.ParseSkin("models/mapobjects/gomaker/lamp_default.skin", os.DirFS("data/baseq3"))
+	if err != nil {
+		t.Fatalf("ParseSkin returned error %s", err)
+	}
+	expected := map[string]int{"models/mapobjects/gomaker/lamp_skin": 1, "testmap/test_shader": 1}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
 	}
 }
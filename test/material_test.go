@@ -1,10 +1,12 @@
 package test
 
 import (
+	"os"
 	"reflect"
 	"testing"
 
 	"gomaker/internal/material"
+	"gomaker/internal/vfs"
 )
 
 func TestGetMaterial(t *testing.T) {
@@ -14,7 +16,7 @@ func TestGetMaterial(t *testing.T) {
 	}{
 		{
 			"( 104 400 176 ) ( 112 400 192 ) ( 104 272 176 ) common/caulk 32 0 0 0.5 0.5 134217728 0 0",
-			"",
+			"common/caulk",
 		},
 		{"// Entity 0", ""},
 		{
@@ -26,8 +28,8 @@ func TestGetMaterial(t *testing.T) {
 			"testmap/test-texture-2",
 		},
 		{
-			"( 216 -64 120 ) ( 200 -192 128 ) ( 216 -192 120 ) common/caulk 0 32 0 0.5 0.5 134217728 0 0",
-			"",
+			"( 216 -64 120 ) ( 200 -192 128 ) ( 216 -192 120 ) textures/effects/custom_glow 0 32 0 0.5 0.5 134217728 0 0",
+			"effects/custom_glow",
 		},
 		{
 			"( 112 -64 192 ) ( 128 -192 184 ) ( 112 -192 192 ) testmap_a1/23-texture 384 0 0 0.25 0.25 134217728 0 0",
@@ -47,28 +49,6 @@ func TestGetMaterial(t *testing.T) {
 	}
 }
 
-func TestIsCustomMaterial(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{"common/caulk", false},
-		{"common_alphascale/", false},
-		{"sfx/", false},
-		{"sfx/something", false},
-		{"liquids/", false},
-		{"effects/", false},
-		{"testmap/", true},
-		{"testmap2/", true},
-	}
-	for _, test := range tests {
-		value := material.IsCustomMaterial(test.input)
-		if value != test.expected {
-			t.Errorf("Expected %v got %s", value, test.input)
-		}
-	}
-}
-
 func TestFormatPath(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -96,10 +76,20 @@ func TestIsTexture(t *testing.T) {
 		{"testmap/test_texture", true, "textures/testmap/test_texture.jpg"},
 		{"testmap/test_texture_2", false, "testmap/test_texture_2"},
 		{"testmap/test_texture_3", true, "textures/testmap/test_texture_3.tga"},
+		{"env/skymap/space_rt", true, "env/skymap/space_rt.tga"},
+		{"env/skymap/missing_rt", false, "env/skymap/missing_rt"},
+		{"common/caulk", true, "textures/common/caulk.tga"},
+		{"video/intro.roq", true, "video/intro.roq"},
+		{"video/missing.roq", false, "video/missing.roq"},
+	}
+	files, err := vfs.Open("data/baseq3")
+	if err != nil {
+		t.Fatalf("Open returned error %s", err)
 	}
-	baseFolderPath := "data/baseq3/"
+	defer files.Close()
+
 	for _, test := range tests {
-		actualBool, actualTexture := material.IsTexture(test.input, baseFolderPath)
+		actualBool, actualTexture := material.IsTexture(test.input, files)
 		if actualBool != test.expectedBool {
 			t.Errorf("Expected %v got %v for %v", test.expectedBool, actualBool, test.input)
 		}
@@ -156,7 +146,7 @@ func TestSortMaterials(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		actual := material.SortMaterials(test.input, "data/baseq3/")
+		actual := material.SortMaterials(test.input, os.DirFS("data/baseq3"))
 		equalTextures := reflect.DeepEqual(actual.Textures, test.expected.Textures)
 		if !equalTextures {
 			t.Errorf(
@@ -192,7 +182,7 @@ func TestAddTexturePathWithExtension(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		actual := material.AddTexturePathWithExtension(test.input, "data/baseq3/")
+		actual := material.AddTexturePathWithExtension(test.input, os.DirFS("data/baseq3"))
 		if !reflect.DeepEqual(actual, test.expected) {
 			t.Errorf("Expected %v got %v for %v", test.expected, actual, test.input)
 		}
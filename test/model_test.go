@@ -0,0 +1,455 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gomaker/internal/model"
+)
+
+func TestReadMD3(t *testing.T) {
+	actual, err := model.ReadMD3File("data/baseq3/models/mapobjects/gomaker/lamp.md3")
+	if err != nil {
+		t.Fatalf("ReadMD3File returned error %s", err)
+	}
+
+	if actual.Name != "models/mapobjects/gomaker/lamp.md3" || actual.Version != 15 {
+		t.Errorf("Unexpected header %s version %d", actual.Name, actual.Version)
+	}
+	if len(actual.Frames) != 2 || actual.Frames[1].Name != "frame1" || actual.Frames[0].Radius != 2 {
+		t.Errorf("Unexpected frames %v", actual.Frames)
+	}
+	if actual.NumTags != 1 || len(actual.Tags) != 2 {
+		t.Fatalf("Expected one tag for each of 2 frames got %d %v", actual.NumTags, actual.Tags)
+	}
+	if actual.Tags[1].Name != "tag_light" || actual.Tags[1].Origin != [3]float32{0, 0, 1} {
+		t.Errorf("Unexpected tag %v", actual.Tags[1])
+	}
+
+	expectedSurfaces := []model.Surface{
+		{
+			Name:         "base",
+			NumFrames:    2,
+			NumVerts:     3,
+			NumTriangles: 1,
+			Shaders:      []string{"models/mapobjects/gomaker/lamp.tga"},
+		},
+		{
+			Name:         "glow",
+			NumFrames:    2,
+			NumVerts:     3,
+			NumTriangles: 1,
+			Shaders:      []string{"textures/testmap/test_shader"},
+		},
+		{Name: "skinned", NumFrames: 2, NumVerts: 3, NumTriangles: 1, Shaders: []string{""}},
+	}
+	if !reflect.DeepEqual(actual.Surfaces, expectedSurfaces) {
+		t.Errorf("Expected %v got %v", expectedSurfaces, actual.Surfaces)
+	}
+
+	expectedShaders := []string{"models/mapobjects/gomaker/lamp.tga", "textures/testmap/test_shader"}
+	if !reflect.DeepEqual(actual.ShaderNames(), expectedShaders) {
+		t.Errorf("Expected %v got %v", expectedShaders, actual.ShaderNames())
+	}
+}
+
+func TestReadMD3Invalid(t *testing.T) {
+	valid, err := os.ReadFile("data/baseq3/models/mapobjects/gomaker/lamp.md3")
+	if err != nil {
+		t.Fatalf("Reading fixture failed: %s", err)
+	}
+	withInt := func(offset int, value int32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[offset:], uint32(value))
+		return data
+	}
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte{}, "invalid model: file too short for an md3 header (0 bytes)"},
+		{append([]byte("IDP2"), valid[4:]...), `invalid model: unsupported md3 ident "IDP2"`},
+		{withInt(4, 16), "invalid model: unsupported md3 version 16, expected 15"},
+		{withInt(76, 1000), "invalid model: frames out of bounds (offset 108, count 1000)"},
+		{withInt(84, 4), "invalid model: surface 3 out of bounds (offset 1224)"},
+		{withInt(84, 1<<30), "invalid model: 1073741824 surfaces exceed 1224 bytes"},
+		{withInt(80, 1<<30), "invalid model: 1073741824 tags in 2 frames exceed 1224 bytes"},
+		{withInt(100, -1), "invalid model: surface 0 out of bounds (offset -1)"},
+	}
+
+	for _, test := range tests {
+		_, err := model.ReadMD3(bytes.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %s got %v", test.expected, err)
+		}
+		if !errors.Is(err, model.ErrInvalidModel) {
+			t.Errorf("Expected ErrInvalidModel got %v", err)
+		}
+	}
+}
+
+func TestModelShaderNames(t *testing.T) {
+	files := os.DirFS("data/baseq3")
+	actual, err := model.ShaderNames(files, "models/mapobjects/gomaker/lamp.md3")
+	if err != nil {
+		t.Errorf("ShaderNames returned error %s", err)
+	}
+	if len(actual) != 2 {
+		t.Errorf("Expected 2 shaders got %v", actual)
+	}
+
+	actual, err = model.ShaderNames(files, "models/test-model-2.ase")
+	if err != nil {
+		t.Errorf("ShaderNames returned error %s", err)
+	}
+	if len(actual) != 3 {
+		t.Errorf("Expected 3 shaders got %v", actual)
+	}
+
+	animated := []string{"models/mapobjects/gomaker/robot.iqm", "models/mapobjects/gomaker/flag.mdr"}
+	for _, modelPath := range animated {
+		actual, err = model.ShaderNames(files, modelPath)
+		if err != nil {
+			t.Errorf("ShaderNames returned error %s for %s", err, modelPath)
+		}
+		if len(actual) != 2 {
+			t.Errorf("Expected 2 shaders got %v for %s", actual, modelPath)
+		}
+	}
+
+	_, err = model.ShaderNames(files, "models/test-model.lwo")
+	if !errors.Is(err, model.ErrUnsupportedModel) {
+		t.Errorf("Expected ErrUnsupportedModel got %v", err)
+	}
+}
+
+func TestReadASE(t *testing.T) {
+	actual, err := model.ReadASEFile("data/baseq3/models/mapobjects/gomaker/crate.ase")
+	if err != nil {
+		t.Fatalf("ReadASEFile returned error %s", err)
+	}
+
+	if len(actual.Materials) != 2 || len(actual.Materials[0].SubMaterials) != 3 {
+		t.Fatalf("Expected 2 materials, the first with 3 submaterials, got %v", actual.Materials)
+	}
+	side := actual.Materials[0].SubMaterials[0]
+	if side.Name != "side" || side.Bitmap != `..\..\models\mapobjects\gomaker\crate_side.tga` {
+		t.Errorf("Expected the diffuse bitmap for side got %v", side)
+	}
+	expectedObjects := []model.AseObject{{Name: "crate", MaterialRef: 0}}
+	if !reflect.DeepEqual(actual.Objects, expectedObjects) {
+		t.Errorf("Expected %v got %v", expectedObjects, actual.Objects)
+	}
+
+	expectedShaders := []string{
+		"models/mapobjects/gomaker/crate_side",
+		"textures/testmap/test_shader",
+		"textures/testmap/test_texture",
+		"*unused",
+	}
+	if !reflect.DeepEqual(actual.ShaderNames(), expectedShaders) {
+		t.Errorf("Expected %v got %v", expectedShaders, actual.ShaderNames())
+	}
+}
+
+func TestReadASEUnbalanced(t *testing.T) {
+	actual, err := model.ReadASEFile("data/baseq3/models/test-model.ase")
+	if err != nil {
+		t.Fatalf("ReadASEFile returned error %s", err)
+	}
+	expected := []string{"textures/testmap/test_model_texture_1"}
+	if !reflect.DeepEqual(actual.ShaderNames(), expected) {
+		t.Errorf("Expected %v got %v", expected, actual.ShaderNames())
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"*MATERIAL_LIST {\n}\n}", "invalid model: unexpected }"},
+		{"{ *MATERIAL_COUNT 0 }", "invalid model: block without a key"},
+		{`*MATERIAL_NAME "open`, "invalid model: unterminated string on line 1"},
+	}
+	for _, test := range tests {
+		_, err := model.ReadASE(strings.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %s got %v", test.expected, err)
+		}
+	}
+}
+
+func TestShaderPath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"//../textures/testmap/test_texture.jpg", "textures/testmap/test_texture"},
+		{`..\textures\texture_test\concrete_tile.tga`, "textures/texture_test/concrete_tile"},
+		{`C:\Games\Quake3\baseq3\models\mapobjects\lamp.tga`, "models/mapobjects/lamp"},
+		{"../../gomaker/crate.tga", "gomaker/crate"},
+		{`D:\art\crate.tga`, "art/crate"},
+		{"textures/testmap/test_shader", "textures/testmap/test_shader"},
+		{"textures", "textures"},
+	}
+	for _, test := range tests {
+		actual := model.ShaderPath(test.input)
+		if actual != test.expected {
+			t.Errorf("Expected %s got %s for %s", test.expected, actual, test.input)
+		}
+	}
+}
+
+func TestReadOBJ(t *testing.T) {
+	files := os.DirFS("data/baseq3")
+	actual, err := model.ReadOBJFS(files, "models/mapobjects/gomaker/barrel.obj")
+	if err != nil {
+		t.Fatalf("ReadOBJFS returned error %s", err)
+	}
+	expected := &model.OBJ{
+		MaterialLibraries: []string{"barrel_body.mtl", "barrel_trim.mtl", "../gomaker/barrel_decals.mtl"},
+		Materials:         []string{"body", "textures/testmap/test_shader", "decal", "missing_material"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	expectedLibraries := []string{
+		"models/mapobjects/gomaker/barrel_body.mtl",
+		"models/mapobjects/gomaker/barrel_trim.mtl",
+		"models/mapobjects/gomaker/barrel_decals.mtl",
+	}
+	actualLibraries := actual.LibraryPaths("models/mapobjects/gomaker/barrel.obj")
+	if !reflect.DeepEqual(actualLibraries, expectedLibraries) {
+		t.Errorf("Expected %v got %v", expectedLibraries, actualLibraries)
+	}
+}
+
+func TestReadMTL(t *testing.T) {
+	input := strings.Join([]string{
+		"# comment",
+		"map_Kd ignored/before/newmtl.tga",
+		"newmtl first",
+		"\tmap_Kd -s 1 1 1 -o 0.5 0.5 textures/a/diffuse.tga",
+		"MAP_KA ambient occlusion.tga",
+		"map_d -imfchan m -clamp on alpha.tga",
+		"bump -bm 0.2 normal.tga",
+		"newmtl\tsecond",
+		"map_bump second_n.tga # trailing comment",
+	}, "\n")
+	actual, err := model.ReadMTL(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadMTL returned error %s", err)
+	}
+	expected := []model.MTLMaterial{
+		{
+			Name:    "first",
+			Diffuse: "textures/a/diffuse.tga",
+			Ambient: "ambient occlusion.tga",
+			Alpha:   "alpha.tga",
+			Bump:    "normal.tga",
+		},
+		{Name: "second", Bump: "second_n.tga"},
+	}
+	if !reflect.DeepEqual(actual.Materials, expected) {
+		t.Errorf("Expected %v got %v", expected, actual.Materials)
+	}
+
+	expectedNames := []string{"second", "models/crate/second_n"}
+	actualNames := actual.ShaderNames("models/crate", []string{"SECOND"})
+	if !reflect.DeepEqual(actualNames, expectedNames) {
+		t.Errorf("Expected %v got %v", expectedNames, actualNames)
+	}
+}
+
+func TestMaterialTexturePath(t *testing.T) {
+	tests := []struct {
+		texture  string
+		expected string
+	}{
+		{"barrel.tga", "models/barrel/barrel"},
+		{"../shared/metal.jpg", "models/shared/metal"},
+		{`..\..\textures\testmap\test_texture.jpg`, "textures/testmap/test_texture"},
+		{"/long/path/for/some/reason/textures/testmap/test_texture.jpg", "textures/testmap/test_texture"},
+		{`\slash\wrong\way\textures\texture_test\concrete_tile.jpg`, "textures/texture_test/concrete_tile"},
+		{`C:\art\barrel.tga`, "art/barrel"},
+	}
+	for _, test := range tests {
+		actual := model.MaterialTexturePath("models/barrel", test.texture)
+		if actual != test.expected {
+			t.Errorf("Expected %s got %s for %s", test.expected, actual, test.texture)
+		}
+	}
+}
+
+func TestObjShaderNames(t *testing.T) {
+	files := os.DirFS("data/baseq3")
+	actual, err := model.ObjShaderNames(files, "models/mapobjects/gomaker/barrel.obj")
+	if err != nil {
+		t.Fatalf("ObjShaderNames returned error %s", err)
+	}
+	expected := []string{
+		"body",
+		"models/mapobjects/gomaker/barrel_body",
+		"models/mapobjects/gomaker/barrel_ao",
+		"models/mapobjects/gomaker/barrel_body_n",
+		"textures/testmap/test_shader",
+		"decal",
+		"textures/testmap/test_texture",
+		"models/mapobjects/gomaker/decal_alpha",
+		"missing_material",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	_, err = model.ObjShaderNames(fstest.MapFS{
+		"models/a.obj": &fstest.MapFile{Data: []byte("mtllib missing.mtl\nusemtl a\n")},
+	}, "models/a.obj")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a missing material library got %v", err)
+	}
+}
+
+func TestReadIQM(t *testing.T) {
+	actual, err := model.ReadIQMFile("data/baseq3/models/mapobjects/gomaker/robot.iqm")
+	if err != nil {
+		t.Fatalf("ReadIQMFile returned error %s", err)
+	}
+
+	expectedMeshes := []model.IQMMesh{
+		{Name: "body", Material: "models/mapobjects/gomaker/robot.tga", NumVertexes: 3, NumTriangles: 1},
+		{
+			Name:          "visor",
+			Material:      "textures/testmap/test_shader",
+			FirstVertex:   3,
+			NumVertexes:   3,
+			FirstTriangle: 1,
+			NumTriangles:  1,
+		},
+		{
+			Name:          "visor",
+			Material:      "textures/testmap/test_shader",
+			FirstVertex:   6,
+			NumVertexes:   3,
+			FirstTriangle: 2,
+			NumTriangles:  1,
+		},
+	}
+	if actual.Version != 2 || !reflect.DeepEqual(actual.Meshes, expectedMeshes) {
+		t.Errorf("Expected version 2 with %v got %d %v", expectedMeshes, actual.Version, actual.Meshes)
+	}
+
+	expectedShaders := []string{"models/mapobjects/gomaker/robot.tga", "textures/testmap/test_shader"}
+	if !reflect.DeepEqual(actual.ShaderNames(), expectedShaders) {
+		t.Errorf("Expected %v got %v", expectedShaders, actual.ShaderNames())
+	}
+}
+
+func TestReadIQMInvalid(t *testing.T) {
+	valid, err := os.ReadFile("data/baseq3/models/mapobjects/gomaker/robot.iqm")
+	if err != nil {
+		t.Fatalf("Reading fixture failed: %s", err)
+	}
+	withInt := func(offset int, value uint32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[offset:], value)
+		return data
+	}
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{valid[:100], "invalid model: file too short for an iqm header (100 bytes)"},
+		{
+			append([]byte("INTERQUAKEMODEX\x00"), valid[16:]...),
+			`invalid model: unsupported iqm magic "INTERQUAKEMODEX"`,
+		},
+		{withInt(16, 1), "invalid model: unsupported iqm version 1, expected 2"},
+		{withInt(20, 1000), "invalid model: iqm file size 1000 exceeds 276 bytes"},
+		{withInt(36, 100), "invalid model: meshes out of bounds (offset 204, count 100)"},
+		{withInt(208, 500), "mesh 0 material: invalid model: text offset 500 out of bounds (77 bytes)"},
+	}
+
+	for _, test := range tests {
+		_, err := model.ReadIQM(bytes.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %s got %v", test.expected, err)
+		}
+		if !errors.Is(err, model.ErrInvalidModel) {
+			t.Errorf("Expected ErrInvalidModel got %v", err)
+		}
+	}
+}
+
+func TestReadMDR(t *testing.T) {
+	actual, err := model.ReadMDRFile("data/baseq3/models/mapobjects/gomaker/flag.mdr")
+	if err != nil {
+		t.Fatalf("ReadMDRFile returned error %s", err)
+	}
+
+	if actual.Name != "models/mapobjects/gomaker/flag.mdr" || actual.Version != 2 || actual.NumFrames != 1 {
+		t.Errorf("Unexpected header %s version %d frames %d", actual.Name, actual.Version, actual.NumFrames)
+	}
+	expectedSurfaces := []model.MDRSurface{
+		{Name: "cloth", Shader: "models/mapobjects/gomaker/flag.tga", NumVerts: 3, NumTriangles: 1},
+		{Name: "pole", Shader: "textures/testmap/test_shader", NumVerts: 3, NumTriangles: 1},
+	}
+	if len(actual.LODs) != 2 {
+		t.Fatalf("Expected 2 lods got %v", actual.LODs)
+	}
+	for _, lod := range actual.LODs {
+		if !reflect.DeepEqual(lod.Surfaces, expectedSurfaces) {
+			t.Errorf("Expected %v got %v", expectedSurfaces, lod.Surfaces)
+		}
+	}
+
+	expectedShaders := []string{"models/mapobjects/gomaker/flag.tga", "textures/testmap/test_shader"}
+	if !reflect.DeepEqual(actual.ShaderNames(), expectedShaders) {
+		t.Errorf("Expected %v got %v", expectedShaders, actual.ShaderNames())
+	}
+}
+
+func TestReadMDRInvalid(t *testing.T) {
+	valid, err := os.ReadFile("data/baseq3/models/mapobjects/gomaker/flag.mdr")
+	if err != nil {
+		t.Fatalf("Reading fixture failed: %s", err)
+	}
+	withInt := func(offset int, value int32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[offset:], uint32(value))
+		return data
+	}
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{valid[:50], "invalid model: file too short for an mdr header (50 bytes)"},
+		{append([]byte("RDM4"), valid[4:]...), `invalid model: unsupported mdr ident "RDM4"`},
+		{withInt(4, 3), "invalid model: unsupported mdr version 3, expected 2"},
+		{withInt(84, 3), "invalid model: lod 2 out of bounds (offset 800)"},
+		{withInt(84, 1<<30), "invalid model: 1073741824 lods exceed 800 bytes"},
+		{withInt(88, -1), "invalid model: lod 0 out of bounds (offset -1)"},
+		{withInt(104, 1<<30), "lod 0: invalid model: 1073741824 surfaces exceed 800 bytes"},
+		{withInt(112, 0), "invalid model: lod 0 has end offset 0"},
+	}
+
+	for _, test := range tests {
+		_, err := model.ReadMDR(bytes.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %s got %v", test.expected, err)
+		}
+		if !errors.Is(err, model.ErrInvalidModel) {
+			t.Errorf("Expected ErrInvalidModel got %v", err)
+		}
+	}
+}
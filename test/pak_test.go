@@ -0,0 +1,88 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"gomaker/internal/pak"
+	"gomaker/internal/vfs"
+)
+
+func TestDefaultArchives(t *testing.T) {
+	actual := pak.DefaultArchives()
+	if len(actual) != 9 || actual[0] != "pak0.pk3" || actual[8] != "pak8.pk3" {
+		t.Errorf("Expected pak0.pk3 through pak8.pk3 got %v", actual)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		archives         []string
+		expectedArchives []string
+		expectedFiles    int
+	}{
+		{pak.DefaultArchives(), []string{"pak0.pk3"}, 4},
+		{[]string{"pak1.pk3", "pak0.pk3"}, []string{"pak0.pk3"}, 4},
+		{[]string{}, []string{}, 0},
+	}
+	files, err := vfs.Open("data/baseq3")
+	if err != nil {
+		t.Fatalf("Opening the vfs failed: %s", err)
+	}
+	defer files.Close()
+
+	for _, test := range tests {
+		actual := pak.Open(files, "data/baseq3", test.archives)
+		if !reflect.DeepEqual(actual.Archives, test.expectedArchives) {
+			t.Errorf("Expected archives %v got %v", test.expectedArchives, actual.Archives)
+		}
+		if len(actual.Files) != test.expectedFiles {
+			t.Errorf("Expected %d files got %d", test.expectedFiles, len(actual.Files))
+		}
+	}
+
+	if len(pak.Open(files, "data/cpma", pak.DefaultArchives()).Archives) != 0 {
+		t.Errorf("Expected no stock archives outside the base path")
+	}
+}
+
+func TestIsStock(t *testing.T) {
+	files, err := vfs.Open("data/baseq3")
+	if err != nil {
+		t.Fatalf("Opening the vfs failed: %s", err)
+	}
+	defer files.Close()
+	stock := pak.Open(files, "data/baseq3", pak.DefaultArchives())
+
+	tests := []struct {
+		resource        string
+		expectedArchive string
+		expectedStock   bool
+	}{
+		{"textures/common/caulk.tga", "pak0.pk3", true},
+		{"textures/common/caulk.jpg", "pak0.pk3", true},
+		{"Textures/Gothic_Block/blocks15.JPG", "pak0.pk3", true},
+		{"sound/world/dontinclude.wav", "pak0.pk3", true},
+		{"scripts/common.shader", "pak0.pk3", true},
+		{"textures/effects/custom_glow.jpg", "", false},
+		{"textures/common/caulk.png", "", false},
+		{"textures/common", "", false},
+	}
+	for _, test := range tests {
+		archive, isStock := stock.IsStock(test.resource)
+		if archive != test.expectedArchive || isStock != test.expectedStock {
+			t.Errorf(
+				"Expected %s %v got %s %v for %s",
+				test.expectedArchive,
+				test.expectedStock,
+				archive,
+				isStock,
+				test.resource,
+			)
+		}
+	}
+
+	if (pak.Index{}).Contains("textures/common/caulk.tga") {
+		t.Errorf("Expected empty index to contain nothing")
+	}
+}
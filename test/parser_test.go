@@ -1,7 +1,10 @@
 package test
 
 import (
+	"errors"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"gomaker/internal/material"
@@ -21,9 +24,12 @@ func TestReadMap(t *testing.T) {
 		},
 		Shaders: map[string]int{"testmap/test_texture_3": 2, "testmap/test_texture": 2},
 	}
-	expectedSounds := map[string]int{"sound/testmap/sound-file.wav": 1}
-	expectedShaderNames := []string{"testmap/test_shader_2", "testmap/test_shader"}
-	actual, actualSounds, actualShaderNames, _ := parser.ReadMap(mapName, "data/baseq3")
+	expectedSounds := map[string]int{"sound/testmap/sound-file.wav": 1, "sound/world/dontinclude.wav": 1}
+	expectedShaderNames := []string{"common/caulk", "testmap/test_shader_2", "testmap/test_shader"}
+	actual, actualSounds, actualShaderNames, _, err := parser.ReadMap(mapName, "data/baseq3")
+	if err != nil {
+		t.Fatalf("ReadMap returned error %s", err)
+	}
 
 	if !reflect.DeepEqual(actual, expected.Textures) {
 		t.Errorf("Expected %v\n got %v", expected.Textures, actual)
@@ -38,65 +44,374 @@ func TestReadMap(t *testing.T) {
 	}
 }
 
-func TestAddMaterials(t *testing.T) {
-	line := "( 104 400 176 ) ( 112 400 192 ) ( 104 272 176 ) testmap/test_shader_3 32 0 0 0.5 0.5 134217728 0 0"
-	materials := map[string]int{"testmap/test_texture_3": 1, "testmap/test_texture": 1}
-	expected := map[string]int{
-		"testmap/test_texture_3": 1,
-		"testmap/test_texture":   1,
-		"testmap/test_shader_3":  1,
+func TestReadBsp(t *testing.T) {
+	expectedTextures := map[string]int{
+		"textures/testmap/test_texture.jpg":         1,
+		"textures/testmap/test_model_texture_1.jpg": 1,
+		"textures/testmap/test_shader_2.tga":        1,
+		"textures/testmap/test_shader_3.jpg":        1,
+	}
+	expectedSounds := map[string]int{"sound/testmap/sound-file.wav": 1, "sound/world/dontinclude.wav": 1}
+	expectedShaderNames := []string{"common/caulk", "testmap/test_shader"}
+	expectedShaderFiles := []string{"common.shader", "testmap.shader"}
+	actual, actualSounds, actualShaderNames, actualShaderFiles, err := parser.ReadBsp(
+		"bsponly",
+		"data/baseq3",
+	)
+	if err != nil {
+		t.Fatalf("ReadBsp returned error %s", err)
+	}
+
+	if !reflect.DeepEqual(actual, expectedTextures) {
+		t.Errorf("Expected %v\n got %v", expectedTextures, actual)
+	}
+
+	if !reflect.DeepEqual(actualSounds, expectedSounds) {
+		t.Errorf("Expected %v got %v", expectedSounds, actualSounds)
+	}
+
+	if !isEqual2(actualShaderNames, expectedShaderNames) {
+		t.Errorf("Expected %v got %v", expectedShaderNames, actualShaderNames)
+	}
+
+	if !isEqual2(actualShaderFiles, expectedShaderFiles) {
+		t.Errorf("Expected %v got %v", expectedShaderFiles, actualShaderFiles)
+	}
+}
+
+func TestReadMapWithPatches(t *testing.T) {
+	expectedTextures := map[string]int{
+		"textures/patchmap/floor.jpg":        1,
+		"textures/patchmap/curve_1.jpg":      1,
+		"textures/patchmap/curve_2.tga":      1,
+		"textures/testmap/test_shader_2.tga": 1,
+		"textures/testmap/test_shader_3.jpg": 1,
+	}
+	expectedShaderNames := []string{"common/caulk", "testmap/test_shader"}
+	actual, actualSounds, actualShaderNames, _, err := parser.ReadMap("patchmap", "data/baseq3")
+	if err != nil {
+		t.Fatalf("ReadMap returned error %s", err)
+	}
+
+	if !reflect.DeepEqual(actual, expectedTextures) {
+		t.Errorf("Expected %v\n got %v", expectedTextures, actual)
+	}
+
+	if len(actualSounds) != 0 {
+		t.Errorf("Expected no sounds got %v", actualSounds)
+	}
+
+	if !isEqual2(actualShaderNames, expectedShaderNames) {
+		t.Errorf("Expected %v got %v", expectedShaderNames, actualShaderNames)
+	}
+}
+
+func TestReadMapBrushFormats(t *testing.T) {
+	tests := []struct {
+		mapName          string
+		expectedTextures map[string]int
+	}{
+		{
+			"primitives",
+			map[string]int{
+				"textures/primitives/wall.jpg":       1,
+				"textures/primitives/trim.tga":       1,
+				"textures/primitives/metal.jpg":      1,
+				"textures/testmap/test_shader_2.tga": 1,
+				"textures/testmap/test_shader_3.jpg": 1,
+			},
+		},
+		{
+			"brushdef3",
+			map[string]int{
+				"textures/primitives/wall.jpg":  1,
+				"textures/primitives/trim.tga":  1,
+				"textures/primitives/metal.jpg": 1,
+			},
+		},
+	}
+	for _, test := range tests {
+		actual, _, _, _, err := parser.ReadMap(test.mapName, "data/baseq3")
+		if err != nil {
+			t.Errorf("ReadMap returned error %s for %s", err, test.mapName)
+		}
+		if !reflect.DeepEqual(actual, test.expectedTextures) {
+			t.Errorf("Expected %v\n got %v for %s", test.expectedTextures, actual, test.mapName)
+		}
+	}
+}
+
+func TestParseBrushDef(t *testing.T) {
+	primitives, err := parser.ParseFile("data/baseq3/maps/primitives.map")
+	if err != nil {
+		t.Fatalf("ParseFile failed: %s", err)
+	}
+	brushes := primitives.Entities[0].Brushes
+	if len(brushes) != 2 {
+		t.Fatalf("Expected 2 brushes got %d", len(brushes))
+	}
+	if brushes[0].Format != parser.BrushPrimitives || brushes[1].Format != parser.BrushClassic {
+		t.Errorf("Expected brushDef and classic brush got %v and %v", brushes[0].Format, brushes[1].Format)
+	}
+	expectedFace := parser.BrushFace{
+		Pos:       parser.Position{Line: 12, Column: 1},
+		Plane:     [3][3]float64{{128, 0, 0}, {128, 128, 0}, {128, 0, -16}},
+		TexMatrix: [2][3]float64{{0.0078125, 0, 0.5}, {0, 0.0078125, 0.25}},
+		Texture:   "common/caulk",
+	}
+	if !reflect.DeepEqual(*brushes[0].Faces[3], expectedFace) {
+		t.Errorf("Expected %v got %v", expectedFace, *brushes[0].Faces[3])
+	}
+	if brushes[0].Faces[2].ContentFlags != 134217728 {
+		t.Errorf("Expected content flags 134217728 got %d", brushes[0].Faces[2].ContentFlags)
 	}
 
-	parser.AddMaterials(line, materials)
-	if !reflect.DeepEqual(materials, expected) {
-		t.Errorf("Expected %v got %v", expected, materials)
+	brushDef3, err := parser.ParseFile("data/baseq3/maps/brushdef3.map")
+	if err != nil {
+		t.Fatalf("ParseFile failed: %s", err)
+	}
+	if brushDef3.Version != 2 {
+		t.Errorf("Expected version 2 got %d", brushDef3.Version)
+	}
+	worldspawn := brushDef3.Entities[0]
+	if len(worldspawn.Brushes) != 1 || worldspawn.Brushes[0].Format != parser.BrushDef3 {
+		t.Fatalf("Expected 1 brushDef3 brush got %v", worldspawn.Brushes)
+	}
+	face := worldspawn.Brushes[0].Faces[3]
+	expectedEquation := [4]float64{1, 0, 0, -128}
+	if face.PlaneEquation != expectedEquation || face.Texture != "textures/common/caulk" {
+		t.Errorf(
+			"Expected %v textures/common/caulk got %v %s",
+			expectedEquation,
+			face.PlaneEquation,
+			face.Texture,
+		)
+	}
+	if len(worldspawn.Patches) != 1 || worldspawn.Patches[0].Texture != "textures/primitives/metal" {
+		t.Errorf("Expected patch with textures/primitives/metal got %v", worldspawn.Patches)
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := `{
+"classname" "worldspawn"
+"message" "Test map"
+{ ( 104 400 176 ) ( 112 400 192 ) ( 104 272 176 ) common/caulk 32 0 0 0.5 0.5 134217728 0 0
+( 96 80 192 ) ( 240 80 128 ) ( 240 80 192 ) testmap/test_texture 0 0 0 0.5 0.5 }
+}
+{ "classname" "misc_model" "model" "models/test-model.ase" }`
+	mapFile, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+
+	if len(mapFile.Entities) != 2 {
+		t.Fatalf("Expected 2 entities got %d", len(mapFile.Entities))
+	}
+
+	worldspawn := mapFile.Entities[0]
+	if worldspawn.Classname() != "worldspawn" {
+		t.Errorf("Expected worldspawn got %s", worldspawn.Classname())
+	}
+	if len(worldspawn.Brushes) != 1 || len(worldspawn.Brushes[0].Faces) != 2 {
+		t.Fatalf("Expected 1 brush with 2 faces got %v", worldspawn.Brushes)
+	}
+
+	expectedFace := parser.BrushFace{
+		Pos:          parser.Position{Line: 4, Column: 3},
+		Plane:        [3][3]float64{{104, 400, 176}, {112, 400, 192}, {104, 272, 176}},
+		Texture:      "common/caulk",
+		Shift:        [2]float64{32, 0},
+		Scale:        [2]float64{0.5, 0.5},
+		ContentFlags: 134217728,
+	}
+	if !reflect.DeepEqual(*worldspawn.Brushes[0].Faces[0], expectedFace) {
+		t.Errorf("Expected %v got %v", expectedFace, *worldspawn.Brushes[0].Faces[0])
+	}
+
+	model := mapFile.Entities[1]
+	expectedPos := parser.Position{Line: 7, Column: 1}
+	if model.Pos != expectedPos {
+		t.Errorf("Expected %v got %v", expectedPos, model.Pos)
+	}
+	if model.ValueForKey("model") != "models/test-model.ase" {
+		t.Errorf("Expected models/test-model.ase got %s", model.ValueForKey("model"))
+	}
+}
+
+func TestParsePatches(t *testing.T) {
+	mapFile, err := parser.ParseFile("data/baseq3/maps/patchmap.map")
+	if err != nil {
+		t.Fatalf("ParseFile failed: %s", err)
+	}
+
+	worldspawn := mapFile.Entities[0]
+	if len(worldspawn.Brushes) != 1 || len(worldspawn.Patches) != 4 {
+		t.Fatalf(
+			"Expected 1 brush and 4 patches got %d and %d",
+			len(worldspawn.Brushes),
+			len(worldspawn.Patches),
+		)
+	}
+	if len(mapFile.Entities[1].Patches) != 1 {
+		t.Errorf("Expected 1 patch in func_group got %d", len(mapFile.Entities[1].Patches))
+	}
+
+	tests := []struct {
+		patch        *parser.Patch
+		typeName     string
+		texture      string
+		width        int
+		height       int
+		subdivisions [2]int
+	}{
+		{worldspawn.Patches[0], "patchDef2", "patchmap/curve_1", 3, 3, [2]int{0, 0}},
+		{worldspawn.Patches[1], "patchDef2", "patchmap/curve_2", 5, 3, [2]int{0, 0}},
+		{worldspawn.Patches[2], "patchDef3", "testmap/test_shader", 3, 3, [2]int{4, 4}},
+		{worldspawn.Patches[3], "patchDef2", "common/caulk", 3, 3, [2]int{0, 0}},
+	}
+	for _, test := range tests {
+		patch := test.patch
+		if patch.Type != test.typeName || patch.Texture != test.texture {
+			t.Errorf("Expected %s %s got %s %s", test.typeName, test.texture, patch.Type, patch.Texture)
+		}
+		if patch.Width != test.width || patch.Height != test.height {
+			t.Errorf(
+				"Expected %dx%d got %dx%d for %s",
+				test.width,
+				test.height,
+				patch.Width,
+				patch.Height,
+				patch.Texture,
+			)
+		}
+		if patch.Subdivisions != test.subdivisions {
+			t.Errorf("Expected %v got %v for %s", test.subdivisions, patch.Subdivisions, patch.Texture)
+		}
+		if len(patch.ControlPoints) != test.width {
+			t.Errorf("Expected %d control point rows got %d", test.width, len(patch.ControlPoints))
+		}
+	}
+
+	expectedVertex := parser.PatchVertex{XYZ: [3]float64{32, 64, 128}, UV: [2]float64{0.25, -0.5}}
+	actualVertex := worldspawn.Patches[1].ControlPoints[1][1]
+	if actualVertex != expectedVertex {
+		t.Errorf("Expected %v got %v", expectedVertex, actualVertex)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{ "classname" }`, "1:15: expected string, found '}'"},
+		{`{ "classname" "worldspawn"`, "1:27: expected key, brush or '}', found end of file"},
+		{"{\n{ ( 0 0 0 ) ( 1 1 1 ) ( 2 2 2 ) common/caulk 0 0 }\n}", "2:3: expected 5 or 8 texture values, found 2"},
+		{`{ "message" "unterminated }`, "1:13: unterminated string"},
+		{"{\n{ ( 0 0 x ) }\n}", `2:9: expected number, found "x"`},
+		{
+			"{\n{\npatchDef2\n{\na/b\n( 3 1 0 0 0 )\n(\n( ( 0 0 0 0 0 ) )\n)\n}\n}\n}",
+			"9:1: expected 3 control point rows, found 1",
+		},
+		{
+			"{\n{\npatchDef2\n{\na/b\n( 1 2 0 0 0 )\n(\n( ( 0 0 0 0 0 ) )\n)\n}\n}\n}",
+			"9:1: expected 2 control points per row, found 1",
+		},
+		{
+			"{\n{\npatchDef2\n{\na/b\n( -3 3 0 0 0 )\n(\n( ( 0 0 0 0 0 ) )\n)\n}\n}\n}",
+			"6:1: patch size must be a whole number from 1 to 32, found -3",
+		},
+		{
+			"{\n{\npatchDef2\n{\na/b\n( 3 2000000000 0 0 0 )\n(\n)\n}\n}\n}",
+			"6:1: patch size must be a whole number from 1 to 32, found 2e+09",
+		},
+		{
+			"{\n{\npatchDef3\n{\na/b\n( 3 2.5 0 0 0 0 0 )\n(\n)\n}\n}\n}",
+			"6:1: patch size must be a whole number from 1 to 32, found 2.5",
+		},
+	}
+	for _, test := range tests {
+		_, err := parser.Parse(strings.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %s got %v for %s", test.expected, err, test.input)
+		}
+	}
+}
+
+func TestLexer(t *testing.T) {
+	input := "// entity 0\n{ \"classname\" /* inline */ \"worldspawn\"\n( 1 -2.5 ) textures/a_b-c }"
+	expected := []parser.Token{
+		{Kind: parser.TokenLBrace, Text: "{", Pos: parser.Position{Line: 2, Column: 1}},
+		{Kind: parser.TokenString, Text: "classname", Pos: parser.Position{Line: 2, Column: 3}},
+		{Kind: parser.TokenString, Text: "worldspawn", Pos: parser.Position{Line: 2, Column: 28}},
+		{Kind: parser.TokenLParen, Text: "(", Pos: parser.Position{Line: 3, Column: 1}},
+		{Kind: parser.TokenWord, Text: "1", Pos: parser.Position{Line: 3, Column: 3}},
+		{Kind: parser.TokenWord, Text: "-2.5", Pos: parser.Position{Line: 3, Column: 5}},
+		{Kind: parser.TokenRParen, Text: ")", Pos: parser.Position{Line: 3, Column: 10}},
+		{Kind: parser.TokenWord, Text: "textures/a_b-c", Pos: parser.Position{Line: 3, Column: 12}},
+		{Kind: parser.TokenRBrace, Text: "}", Pos: parser.Position{Line: 3, Column: 27}},
+		{Kind: parser.TokenEOF, Text: "", Pos: parser.Position{Line: 3, Column: 28}},
+	}
+	lexer, err := parser.NewLexer(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("NewLexer failed: %s", err)
+	}
+	for _, expectedToken := range expected {
+		actual, err := lexer.Next()
+		if err != nil {
+			t.Fatalf("Next failed: %s", err)
+		}
+		if actual != expectedToken {
+			t.Errorf("Expected %v got %v", expectedToken, actual)
+		}
 	}
 }
 
 func TestGetMaterials(t *testing.T) {
-	emptyMap := map[string]int{}
 	tests := []struct {
 		input    string
 		expected map[string]int
 	}{
 		{
-			"( 104 400 176 ) ( 112 400 192 ) ( 104 272 176 ) testmap/texture 32 0 0 0.5 0.5 134217728 0 0",
+			"{\n{\n( 104 400 176 ) ( 112 400 192 ) ( 104 272 176 ) testmap/texture 32 0 0 0.5 0.5 134217728 0 0\n}\n}",
 			map[string]int{"testmap/texture": 1},
 		},
-		{"// Entity 0", emptyMap},
-		{"{", emptyMap},
-		{`"classname" "misc_model"`, emptyMap},
-		{`"origin" "-924 -4 536"`, emptyMap},
-		{`"model" "data/baseq3/models/test-model.ase"`, emptyMap},
-		{`"angles" "-0 0 -180"`, emptyMap},
-		{`"_remap" "*;textures/testmap/test_texture"`, emptyMap},
-		{"}", map[string]int{"testmap/test_texture": 1}},
-		{"// Brush 1337", emptyMap},
 		{
-			"( 96 80 192 ) ( 240 80 128 ) ( 240 80 192 ) testmap/test_texture 461.2879333496 22.0878295898 -26.5999984741 0.2808699906 0.280872494 134217728 0 0",
+			// No "// brush" comments and braces sharing a line with content
+			"{ \"classname\" \"worldspawn\" { ( 0 0 0 ) ( 1 0 0 ) ( 0 1 0 ) testmap/a 0 0 0 1 1 } }",
+			map[string]int{"testmap/a": 1},
+		},
+		{
+			`{
+"classname" "misc_model"
+"model" "models/test-model.ase"
+"_remap" "*;textures/testmap/test_texture"
+}`,
 			map[string]int{"testmap/test_texture": 1},
 		},
-		{"// entity 1", emptyMap},
-		{"{", emptyMap},
-		{"}", emptyMap},
-		{"// brush 0", emptyMap},
-		{"{", emptyMap},
-		{"}", emptyMap},
-		{"// Entity 2", emptyMap},
-		{"{", emptyMap},
-		{`"classname" "misc_model"`, emptyMap},
-		{`"origin" "-924 -4 536"`, emptyMap},
-		{`"model" "data/baseq3/models/test-model.ase"`, emptyMap},
-		{"}", map[string]int{"testmap/test_model_texture_1": 1}},
-		{"// Entity 3", emptyMap},
-		{"{", emptyMap},
-		{`"classname" "misc_model"`, emptyMap},
-		{`"origin" "-924 -4 536"`, emptyMap},
-		{`"model" "data/baseq3/models/test-material.obj"`, emptyMap},
-		{"}", map[string]int{"testmap/test_model_texture_2": 1}},
+		{
+			`{
+"classname" "misc_model"
+"model" "models/test-model.ase"
+}
+{
+"classname" "misc_model"
+"model" "models/test-material.obj"
+}`,
+			map[string]int{"testmap/test_model_texture_1": 1, "testmap/test_model_texture_2": 1},
+		},
+		{"{ \"classname\" \"worldspawn\" }", map[string]int{}},
 	}
 	for index, test := range tests {
-		actual := parser.GetMaterials(test.input)
+		mapFile, err := parser.Parse(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatalf("Parse failed for index %d: %s", index, err)
+		}
+		actual, err := parser.GetMaterials(mapFile, os.DirFS("data/baseq3"))
+		if err != nil {
+			t.Fatalf("GetMaterials failed for index %d: %s", index, err)
+		}
 
 		if !reflect.DeepEqual(actual, test.expected) {
 			t.Errorf(
@@ -110,80 +425,94 @@ func TestGetMaterials(t *testing.T) {
 	}
 }
 
-func TestHandleBrush(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{
-			"( 104 400 176 ) ( 112 400 192 ) ( 104 272 176 ) testmap/texture 32 0 0 0.5 0.5 134217728 0 0",
-			"testmap/texture",
-		},
-		{"// Entity 0", ""},
-		{"// Brush 1337", ""},
-		{
-			"( 96 80 192 ) ( 240 80 128 ) ( 240 80 192 ) testmap/test_texture 461.2879333496 22.0878295898 -26.5999984741 0.2808699906 0.280872494 134217728 0 0",
-			"testmap/test_texture",
-		},
-		{"// entity 1", ""},
-		{"// brush 0", ""},
+func TestGetSounds(t *testing.T) {
+	input := `{
+"classname" "target_speaker"
+"noise" "sound/testmap/sound-file.wav"
+}
+{
+"classname" "target_speaker"
+"noise" "sound/world/base-file.wav"
+}`
+	expected := map[string]int{"sound/testmap/sound-file.wav": 1}
+	mapFile, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
 	}
-	for _, test := range tests {
-		actual := parser.HandleBrush(test.input)
-		if actual != test.expected {
-			t.Errorf("Expected %v got %v for %v", test.expected, actual, test)
-		}
+
+	actual := parser.GetSounds(mapFile, os.DirFS("data/baseq3"))
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestHandleBrush(t *testing.T) {
+	brush := &parser.Brush{Faces: []*parser.BrushFace{
+		{Texture: "testmap/texture"},
+		{Texture: "testmap/test_texture"},
+		{Texture: "testmap/texture"},
+		{Texture: "textures/sfx/+0flame.v2"},
+	}}
+	expected := map[string]int{"testmap/texture": 2, "testmap/test_texture": 1, "sfx/+0flame.v2": 1}
+
+	actual := parser.HandleBrush(brush)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
 	}
 }
 
 func TestHandleEntity(t *testing.T) {
 	tests := []struct {
-		input    []string
+		input    []parser.Property
 		expected map[string]int
 	}{
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"origin" "-924 -4 536"`,
-			`"model" "data/baseq3/models/test-model.ase"`,
-			`"angles" "-0 0 -180"`,
-			"}",
+		{[]parser.Property{
+			{Key: "classname", Value: "misc_model"},
+			{Key: "origin", Value: "-924 -4 536"},
+			{Key: "model", Value: "models/test-model.ase"},
+			{Key: "angles", Value: "-0 0 -180"},
 		}, map[string]int{"testmap/test_model_texture_1": 1}},
-		{[]string{
-			"{",
-			`"classname" "misc_model"`,
-			`"origin" "-924 -4 536"`,
-			`"model" "maps/models/test-model.ase"`,
-			`"angles" "-0 0 -180"`,
-			`"_remap" "*;textures/testmap/test_texture"`,
-			"}",
+		{[]parser.Property{
+			{Key: "classname", Value: "misc_model"},
+			{Key: "origin", Value: "-924 -4 536"},
+			{Key: "model", Value: "maps/models/test-model.ase"},
+			{Key: "angles", Value: "-0 0 -180"},
+			{Key: "_remap", Value: "*;textures/testmap/test_texture"},
 		}, map[string]int{"testmap/test_texture": 1}},
 	}
 	for _, test := range tests {
-		actual := parser.HandleEntity(test.input)
+		actual, err := parser.HandleEntity(
+			&parser.Entity{Properties: test.input},
+			os.DirFS("data/baseq3"),
+		)
+		if err != nil {
+			t.Errorf("HandleEntity returned error %s for %v", err, test)
+		}
 		if !reflect.DeepEqual(actual, test.expected) {
 			t.Errorf("Expected %v got %v for %v", test.expected, actual, test)
 		}
 	}
 }
 
-func TestIsClosingBracket(t *testing.T) {
+func TestReadMapErrors(t *testing.T) {
 	tests := []struct {
-		input    string
-		expected bool
+		mapName  string
+		expected error
 	}{
-		{"{", false},
-		{"}", true},
-		{")", false},
-		{"// Entity 0", false},
-		{"", false},
+		{"missing", parser.ErrMapNotFound},
+		{"missingasset", material.ErrMissingAsset},
 	}
 	for _, test := range tests {
-		actual := parser.IsClosingBracket(test.input)
-		if actual != test.expected {
-			t.Errorf("Expected %v got %v for %s", test.expected, actual, test.input)
+		_, _, _, _, err := parser.ReadMap(test.mapName, "data/baseq3")
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Expected %v got %v for %s", test.expected, err, test.mapName)
 		}
 	}
+
+	_, _, _, _, err := parser.ReadBsp("missing", "data/baseq3")
+	if !errors.Is(err, parser.ErrMapNotFound) {
+		t.Errorf("Expected ErrMapNotFound got %v", err)
+	}
 }
 
 func TestMergeMaps(t *testing.T) {
@@ -1,9 +1,13 @@
 package test
 
 import (
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
+	"gomaker/internal/material"
 	"gomaker/internal/shader"
 )
 
@@ -24,10 +28,13 @@ func TestExtractTexturesFromUsedShaders(t *testing.T) {
 	}
 	expectedShaderNames := []string{"testmap/test_shader_2", "testmap/test_shader"}
 	expectedShaderFiles := []string{"test_shader_2.shader", "testmap.shader"}
-	actual, actualShaderNames, actualShaderFiles := shader.ExtractTexturesFromUsedShaders(
+	actual, actualShaderNames, actualShaderFiles, err := shader.ExtractTexturesFromUsedShaders(
 		input,
 		"data/baseq3/scripts",
 	)
+	if err != nil {
+		t.Fatalf("ExtractTexturesFromUsedShaders returned error %s", err)
+	}
 
 	if !reflect.DeepEqual(actual, expectedTextures) {
 		t.Errorf("Expected textures %v got %v for %v", expectedTextures, actual, input)
@@ -52,6 +59,258 @@ func TestExtractTexturesFromUsedShaders(t *testing.T) {
 	}
 }
 
+func TestExtractSkyAndAnimatedTextures(t *testing.T) {
+	input := map[string]int{"skymap/space": 1, "skymap/anim": 1}
+	expectedTextures := map[string]int{
+		"env/skymap/space_rt": 1,
+		"env/skymap/space_lf": 1,
+		"env/skymap/space_bk": 1,
+		"env/skymap/space_ft": 1,
+		"env/skymap/space_up": 1,
+		"env/skymap/space_dn": 1,
+		"skymap/anim_1":       1,
+		"skymap/anim_2":       1,
+		"skymap/anim_3":       1,
+	}
+	expectedFiles := map[string]int{
+		"env/skymap/space_rt.tga":    1,
+		"env/skymap/space_lf.tga":    1,
+		"env/skymap/space_bk.tga":    1,
+		"env/skymap/space_ft.tga":    1,
+		"env/skymap/space_up.tga":    1,
+		"env/skymap/space_dn.tga":    1,
+		"textures/skymap/anim_1.tga": 1,
+		"textures/skymap/anim_2.jpg": 1,
+		"textures/skymap/anim_3.tga": 1,
+	}
+	actual, _, actualShaderFiles, err := shader.ExtractTexturesFromUsedShaders(input, "data/baseq3/scripts")
+	if err != nil {
+		t.Fatalf("ExtractTexturesFromUsedShaders returned error %s", err)
+	}
+
+	if !reflect.DeepEqual(actual, expectedTextures) {
+		t.Errorf("Expected textures %v got %v", expectedTextures, actual)
+	}
+	if !isEqual(actualShaderFiles, []string{"skymap.shader"}) {
+		t.Errorf("Expected shader files [skymap.shader] got %v", actualShaderFiles)
+	}
+
+	actualFiles := material.AddTexturePathWithExtension(actual, os.DirFS("data/baseq3"))
+	if !reflect.DeepEqual(actualFiles, expectedFiles) {
+		t.Errorf("Expected files %v got %v", expectedFiles, actualFiles)
+	}
+}
+
+func TestExtractReferencedShaders(t *testing.T) {
+	input := map[string]int{"remap/base": 1}
+	expectedTextures := map[string]int{
+		"remap/light":      1,
+		"remap/base_tex":   1,
+		"remap/target_tex": 1,
+		"remap/back_tex":   1,
+		"remap/missing":    1,
+	}
+	expectedShaderNames := []string{"remap/base", "remap/target", "remap/back"}
+	actual, actualShaderNames, actualShaderFiles, err := shader.ExtractTexturesFromUsedShaders(
+		input,
+		"data/baseq3/scripts",
+	)
+	if err != nil {
+		t.Fatalf("ExtractTexturesFromUsedShaders returned error %s", err)
+	}
+
+	if !reflect.DeepEqual(actual, expectedTextures) {
+		t.Errorf("Expected textures %v got %v", expectedTextures, actual)
+	}
+	if !isEqual(actualShaderNames, expectedShaderNames) {
+		t.Errorf("Expected shader names %v got %v", expectedShaderNames, actualShaderNames)
+	}
+	if !isEqual(actualShaderFiles, []string{"remap.shader"}) {
+		t.Errorf("Expected shader files [remap.shader] got %v", actualShaderFiles)
+	}
+}
+
+func TestResolveUsedShaders(t *testing.T) {
+	index := map[string]shader.Shader{
+		"a/one": {Name: "a/one", Directives: []shader.Directive{
+			{Name: "q3map_baseShader", Args: []string{"textures/a/two"}},
+		}},
+		"a/two": {Name: "a/two", Directives: []shader.Directive{
+			{Name: "q3map_remapShader", Args: []string{"textures/a/one"}},
+			{Name: "Q3MAP_BACKSHADER", Args: []string{"textures/a/three"}},
+		}},
+	}
+	expected := map[string]int{"a/one": 2, "a/two": 1, "a/three": 1}
+
+	actual := shader.ResolveUsedShaders(map[string]int{"a/one": 2}, index)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestExtractDuplicateShaders(t *testing.T) {
+	tests := []struct {
+		options             shader.Options
+		expectedTextures    map[string]int
+		expectedShaderFiles []string
+	}{
+		{shader.Options{}, map[string]int{"dupe/from_b": 1}, []string{"dupe_b.shader"}},
+		{
+			shader.Options{UseShaderList: true},
+			map[string]int{"dupe/from_a": 1},
+			[]string{"dupe_a.shader"},
+		},
+	}
+
+	for _, test := range tests {
+		actual, actualShaderNames, actualShaderFiles, err := shader.ExtractTexturesWithOptions(
+			map[string]int{"dupe/shader": 1},
+			os.DirFS("data/baseq3"),
+			"scripts",
+			test.options,
+		)
+		if err != nil {
+			t.Fatalf("ExtractTexturesWithOptions returned error %s", err)
+		}
+		if !reflect.DeepEqual(actual, test.expectedTextures) {
+			t.Errorf("Expected textures %v got %v for %v", test.expectedTextures, actual, test.options)
+		}
+		if !isEqual(actualShaderNames, []string{"dupe/shader"}) {
+			t.Errorf("Expected shader names [dupe/shader] got %v", actualShaderNames)
+		}
+		if !isEqual(actualShaderFiles, test.expectedShaderFiles) {
+			t.Errorf("Expected shader files %v got %v", test.expectedShaderFiles, actualShaderFiles)
+		}
+	}
+}
+
+func TestExtractShadersIgnoresCase(t *testing.T) {
+	actual, actualShaderNames, actualShaderFiles, err := shader.ExtractTexturesWithOptions(
+		map[string]int{"Dupe/Shader": 1, "REMAP/base": 1},
+		os.DirFS("data/baseq3"),
+		"scripts",
+		shader.Options{},
+	)
+	if err != nil {
+		t.Fatalf("ExtractTexturesWithOptions returned error %s", err)
+	}
+	if _, ok := actual["dupe/from_b"]; !ok {
+		t.Errorf("Expected the stage image of dupe/shader got %v", actual)
+	}
+	if _, ok := actual["Dupe/Shader"]; ok {
+		t.Errorf("Expected Dupe/Shader to resolve to a shader got %v", actual)
+	}
+	expectedShaderNames := []string{"dupe/shader", "remap/base", "remap/target", "remap/back"}
+	if !isEqual(actualShaderNames, expectedShaderNames) {
+		t.Errorf("Expected shader names %v got %v", expectedShaderNames, actualShaderNames)
+	}
+	if !isEqual(actualShaderFiles, []string{"dupe_b.shader", "remap.shader"}) {
+		t.Errorf("Expected shader files [dupe_b.shader remap.shader] got %v", actualShaderFiles)
+	}
+
+	files := []shader.ShaderFile{
+		{Name: "a.shader", Shaders: []shader.Shader{{Name: "a/Shader", File: "a.shader"}}},
+		{Name: "b.shader", Shaders: []shader.Shader{{Name: "A/shader", File: "b.shader"}}},
+	}
+	index := shader.IndexShaders(files)
+	if len(index) != 1 || index["a/shader"].File != "b.shader" {
+		t.Errorf("Expected b.shader to replace a.shader got %v", index)
+	}
+	duplicates := shader.FindDuplicates(files)
+	if len(duplicates) != 1 || duplicates[0].Name != "a/Shader" || len(duplicates[0].Definitions) != 2 {
+		t.Errorf("Expected one duplicate with 2 definitions got %v", duplicates)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	files, err := shader.LoadShaderFiles(os.DirFS("data/baseq3"), "scripts", shader.Options{})
+	if err != nil {
+		t.Fatalf("LoadShaderFiles returned error %s", err)
+	}
+	expected := []shader.Duplicate{
+		{
+			Name: "dupe/shader",
+			Used: shader.Definition{File: "dupe_b.shader", Line: 3},
+			Definitions: []shader.Definition{
+				{File: "dupe_a.shader", Line: 1},
+				{File: "dupe_b.shader", Line: 3},
+				{File: "dupe_b.shader", Line: 10},
+			},
+		},
+	}
+
+	actual := shader.FindDuplicates(files)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestReadShaderList(t *testing.T) {
+	expected := []string{
+		"test_shader_2.shader",
+		"testmap.shader",
+		"skymap.shader",
+		"remap.shader",
+		"dupe_a.shader",
+	}
+	actual, err := shader.ReadShaderList(os.DirFS("data/baseq3"), "scripts/shaderlist.txt")
+	if err != nil {
+		t.Fatalf("ReadShaderList failed: %s", err)
+	}
+	if !isEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	_, err = shader.ReadShaderList(os.DirFS("data/baseq3"), "scripts/missing.txt")
+	if err == nil {
+		t.Errorf("Expected error for missing shaderlist")
+	}
+}
+
+func TestVideoPath(t *testing.T) {
+	tests := []struct {
+		videoMap string
+		expected string
+	}{
+		{"intro.roq", "video/intro.roq"},
+		{"video/intro.roq", "video/intro.roq"},
+		{"video\\intro.roq", "video/intro.roq"},
+	}
+	for _, test := range tests {
+		actual := shader.VideoPath(test.videoMap)
+		if actual != test.expected {
+			t.Errorf("Expected %s got %s for %s", test.expected, actual, test.videoMap)
+		}
+	}
+}
+
+func TestSkyBoxImages(t *testing.T) {
+	tests := []struct {
+		box      string
+		expected []string
+	}{
+		{"-", []string{}},
+		{"", []string{}},
+		{
+			"env/mysky",
+			[]string{
+				"env/mysky_rt",
+				"env/mysky_lf",
+				"env/mysky_bk",
+				"env/mysky_ft",
+				"env/mysky_up",
+				"env/mysky_dn",
+			},
+		},
+	}
+	for _, test := range tests {
+		actual := shader.SkyBoxImages(test.box)
+		if !isEqual(actual, test.expected) {
+			t.Errorf("Expected %v got %v for %s", test.expected, actual, test.box)
+		}
+	}
+}
+
 func TestCombineTexturesFromShaders(t *testing.T) {
 	textures := map[string]int{}
 	shaderNames := []string{}
@@ -121,11 +380,14 @@ func TestParseShaderFile(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		actual := shader.ParseShaderFile(
+		actual, err := shader.ParseShaderFile(
 			test.shadersFromMapFile,
 			test.shaderFileName,
 			"data/baseq3/scripts",
 		)
+		if err != nil {
+			t.Fatalf("ParseShaderFile returned error %s", err)
+		}
 		if len(actual) != len(test.expected) {
 			t.Errorf("Expected %v got %v for %v", test.expected, actual, test)
 		}
@@ -160,6 +422,7 @@ func TestShaderIsUsed(t *testing.T) {
 		{map[string]int{"testmap/test_shader": 1}, "testmap/test_shader", true},
 		{map[string]int{"textures/testmap/test_shader_2": 1}, "testmap/test_shader", false},
 		{map[string]int{"testmap/test_shader_3": 1}, "testmap/test_shader", false},
+		{map[string]int{"TestMap/Test_Shader": 1}, "testmap/test_shader", true},
 	}
 
 	for _, test := range tests {
@@ -170,20 +433,148 @@ func TestShaderIsUsed(t *testing.T) {
 	}
 }
 
-func TestIsShaderName(t *testing.T) {
+func TestParseShaders(t *testing.T) {
+	input := `// braces { in } comments are ignored
+textures/testmap/sky { qer_editorimage textures/testmap/sky_qer.tga
+	surfaceparm noimpact
+	surfaceparm nolightmap /* { */
+	skyParms env/testmap/sky 512 -
+	cull none
+	deformVertexes wave 100 sin 3 0 0.2 0.7
+	q3map_sunExt 1 1 1 140 -35 25 2 16
+	{ map $lightmap }
+	{
+		clampMap "textures/testmap/clamp.tga"
+		blendFunc GL_DST_COLOR GL_ZERO
+		rgbGen identity
+		tcMod scale 2 2
+		tcMod scroll 0.1 0
+	}
+	{ animMap 10 textures/testmap/f1.tga textures/testmap/f2.tga
+		alphaFunc GE128
+		depthWrite }
+}
+textures/testmap/second
+{
+	{
+		videoMap intro.roq
+	}
+}
+`
+	shaders, err := shader.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+	if len(shaders) != 2 {
+		t.Fatalf("Expected 2 shaders got %d", len(shaders))
+	}
+
+	sky := shaders[0]
+	if sky.Name != "testmap/sky" || sky.Pos != (shader.Position{Line: 2, Column: 1}) {
+		t.Errorf("Expected testmap/sky at 2:1 got %s at %v", sky.Name, sky.Pos)
+	}
+	if !reflect.DeepEqual(sky.SurfaceParms, []string{"noimpact", "nolightmap"}) {
+		t.Errorf("Expected surfaceparms noimpact nolightmap got %v", sky.SurfaceParms)
+	}
+	expectedSky := shader.SkyParms{FarBox: "env/testmap/sky", CloudHeight: "512", NearBox: "-"}
+	if sky.SkyParms == nil || *sky.SkyParms != expectedSky {
+		t.Errorf("Expected %v got %v", expectedSky, sky.SkyParms)
+	}
+	if sky.Cull != "none" || len(sky.DeformVertexes) != 1 || len(sky.Q3MapDirectives()) != 1 {
+		t.Errorf(
+			"Unexpected cull %s, deformVertexes %v or q3map directives %v",
+			sky.Cull,
+			sky.DeformVertexes,
+			sky.Q3MapDirectives(),
+		)
+	}
+	if len(sky.Stages) != 3 {
+		t.Fatalf("Expected 3 stages got %d", len(sky.Stages))
+	}
+
+	clamp := sky.Stages[1]
+	if clamp.Map != "textures/testmap/clamp.tga" || !clamp.Clamp {
+		t.Errorf("Expected clampMap textures/testmap/clamp.tga got %s %v", clamp.Map, clamp.Clamp)
+	}
+	if !reflect.DeepEqual(clamp.BlendFunc, []string{"GL_DST_COLOR", "GL_ZERO"}) {
+		t.Errorf("Expected blendFunc GL_DST_COLOR GL_ZERO got %v", clamp.BlendFunc)
+	}
+	expectedTcMod := [][]string{{"scale", "2", "2"}, {"scroll", "0.1", "0"}}
+	if !reflect.DeepEqual(clamp.TcMod, expectedTcMod) || clamp.RgbGen[0] != "identity" {
+		t.Errorf("Expected tcMod %v got %v", expectedTcMod, clamp.TcMod)
+	}
+
+	animated := sky.Stages[2]
+	expectedAnimMap := shader.AnimMap{
+		Frequency: 10,
+		Frames:    []string{"textures/testmap/f1.tga", "textures/testmap/f2.tga"},
+	}
+	if animated.AnimMap == nil || !reflect.DeepEqual(*animated.AnimMap, expectedAnimMap) {
+		t.Errorf("Expected %v got %v", expectedAnimMap, animated.AnimMap)
+	}
+	if animated.AlphaFunc != "GE128" || !animated.DepthWrite {
+		t.Errorf("Expected alphaFunc GE128 and depthWrite got %s %v", animated.AlphaFunc, animated.DepthWrite)
+	}
+
+	expectedImages := []string{
+		"env/testmap/sky_rt",
+		"env/testmap/sky_lf",
+		"env/testmap/sky_bk",
+		"env/testmap/sky_ft",
+		"env/testmap/sky_up",
+		"env/testmap/sky_dn",
+		"textures/testmap/clamp.tga",
+		"textures/testmap/f1.tga",
+		"textures/testmap/f2.tga",
+	}
+	if !isEqual(sky.Images(), expectedImages) {
+		t.Errorf("Expected %v got %v", expectedImages, sky.Images())
+	}
+	expectedTextures := map[string]int{
+		"env/testmap/sky_rt": 1,
+		"env/testmap/sky_lf": 1,
+		"env/testmap/sky_bk": 1,
+		"env/testmap/sky_ft": 1,
+		"env/testmap/sky_up": 1,
+		"env/testmap/sky_dn": 1,
+		"testmap/clamp":      1,
+		"testmap/f1":         1,
+		"testmap/f2":         1,
+	}
+	if !reflect.DeepEqual(sky.Textures, expectedTextures) {
+		t.Errorf("Expected %v got %v", expectedTextures, sky.Textures)
+	}
+
+	second := shaders[1]
+	expectedLines := []string{"textures/testmap/second", "{", "\t{", "\t\tvideoMap intro.roq", "\t}", "}"}
+	if !isEqual(second.Lines, expectedLines) {
+		t.Errorf("Expected %v got %v", expectedLines, second.Lines)
+	}
+	if second.Stages[0].VideoMap != "intro.roq" {
+		t.Errorf("Expected videoMap intro.roq got %s", second.Stages[0].VideoMap)
+	}
+	if !isEqual(second.Images(), []string{"video/intro.roq"}) {
+		t.Errorf("Expected [video/intro.roq] got %v", second.Images())
+	}
+	if !reflect.DeepEqual(second.Textures, map[string]int{"video/intro.roq": 1}) {
+		t.Errorf("Expected video/intro.roq texture got %v", second.Textures)
+	}
+}
+
+func TestParseShaderErrors(t *testing.T) {
 	tests := []struct {
-		line     string
-		expected bool
+		input    string
+		expected string
 	}{
-		{"textures/testmap/test_shader_2", true},
-		{"textures/testmap/test_shader_2 {", true},
-		{"map textures/testmap/test_shader_5.tga", false},
+		{"textures/a/b\nsurfaceparm nodraw", `2:1: expected '{', found "surfaceparm"`},
+		{"textures/a/b {\n{ map x.tga\n", "3:1: expected stage directive or '}', found end of file"},
+		{"textures/a/b {\n}\n}", "3:1: expected shader name, found '}'"},
+		{"textures/a/b {\nqer_editorimage \"x.tga\n}", "2:17: unterminated string"},
 	}
-
 	for _, test := range tests {
-		actual := shader.IsShaderName(test.line)
-		if actual != test.expected {
-			t.Errorf("Expected %v got %v for %v", test.expected, actual, test)
+		_, err := shader.Parse(strings.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %s got %v", test.expected, err)
 		}
 	}
 }
@@ -199,3 +590,78 @@ func isEqual(a, b []string) bool {
 	}
 	return true
 }
+
+func TestTrimmedShaderFile(t *testing.T) {
+	input := `textures/testmap/unused
+{
+	surfaceparm nodraw
+}
+
+textures/testmap/kept // trailing comment
+{
+	// stage comment
+	{
+		map textures/testmap/kept.tga
+	}
+}
+`
+	shaders, err := shader.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse returned error %s", err)
+	}
+
+	expected := `// Shaders used by testmap
+
+textures/testmap/kept // trailing comment
+{
+	// stage comment
+	{
+		map textures/testmap/kept.tga
+	}
+}
+`
+	actual := shader.TrimmedShaderFile("testmap", shaders[1:])
+	if actual != expected {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+
+	expected = "// Shaders used by testmap\n"
+	actual = shader.TrimmedShaderFile("testmap", []shader.Shader{})
+	if actual != expected {
+		t.Errorf("Expected %q got %q", expected, actual)
+	}
+}
+
+func TestLoadShaderFilesSkipsBrokenFiles(t *testing.T) {
+	files := fstest.MapFS{
+		"scripts/shaderlist.txt": &fstest.MapFile{Data: []byte("good\nbroken\nmissing\n")},
+		"scripts/good.shader":    &fstest.MapFile{Data: []byte("good/shader\n{\n}\n")},
+		"scripts/broken.shader":  &fstest.MapFile{Data: []byte("broken/shader\n{\n{\n")},
+	}
+	for _, useShaderList := range []bool{true, false} {
+		actual, err := shader.LoadShaderFiles(files, "scripts", shader.Options{UseShaderList: useShaderList})
+		if err != nil {
+			t.Fatalf("LoadShaderFiles returned error %s", err)
+		}
+		if len(actual) != 1 || actual[0].Name != "good.shader" || len(actual[0].Shaders) != 1 {
+			t.Errorf("Expected only good.shader got %v", actual)
+		}
+	}
+}
+
+func TestShaderReferences(t *testing.T) {
+	parsed := shader.Shader{
+		Name: "testmap/base",
+		Directives: []shader.Directive{
+			{Name: "q3map_remapShader", Args: []string{"textures/sfx/+0flame.v2"}},
+			{Name: "q3map_backShader", Args: []string{"testmap/base"}},
+			{Name: "q3map_flare", Args: []string{"flareShader"}},
+			{Name: "surfaceparm", Args: []string{"nolightmap"}},
+		},
+	}
+	expected := []string{"sfx/+0flame.v2", "flareShader"}
+	actual := parsed.ShaderReferences()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"os"
 	"reflect"
 	"testing"
 
@@ -8,6 +9,7 @@ import (
 )
 
 func TestGetSound(t *testing.T) {
+	files := os.DirFS("data/baseq3")
 	tests := []struct {
 		input    string
 		expected string
@@ -31,7 +33,7 @@ func TestGetSound(t *testing.T) {
 		{"}", ""},
 	}
 	for _, test := range tests {
-		actual := sound.GetSound(test.input)
+		actual := sound.GetSound(test.input, files)
 
 		if !reflect.DeepEqual(actual, test.expected) {
 			t.Errorf("Expected %v got %v for %s", test.expected, actual, test.input)
@@ -47,7 +49,7 @@ func TestAddSounds(t *testing.T) {
 		"sound/testmap/sound-file.wav":   1,
 	}
 
-	sound.AddSounds(line, sounds)
+	sound.AddSounds(line, sounds, os.DirFS("data/baseq3"))
 	if !reflect.DeepEqual(sounds, expected) {
 		t.Errorf("Expected %v got %v", expected, sounds)
 	}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"gomaker/internal/vfs"
+)
+
+func TestVfsOpen(t *testing.T) {
+	files, err := vfs.Open("data/vfs")
+	if err != nil {
+		t.Fatalf("Open returned error %s", err)
+	}
+	defer files.Close()
+
+	expectedArchives := []string{filepath.Join("data/vfs", "a.pk3"), filepath.Join("data/vfs", "B.pk3")}
+	if !reflect.DeepEqual(files.Archives, expectedArchives) {
+		t.Errorf("Expected archives %v got %v", expectedArchives, files.Archives)
+	}
+
+	err = fstest.TestFS(
+		files,
+		"textures/shared/wall.jpg",
+		"textures/shared/only_a.tga",
+		"scripts/a.shader",
+	)
+	if err != nil {
+		t.Errorf("TestFS failed: %s", err)
+	}
+
+	_, err = vfs.Open("data/missing")
+	if err == nil {
+		t.Errorf("Expected error opening a missing directory")
+	}
+}
+
+func TestVfsPrecedence(t *testing.T) {
+	files, err := vfs.Open("data/vfs")
+	if err != nil {
+		t.Fatalf("Open returned error %s", err)
+	}
+	defer files.Close()
+
+	tests := []struct {
+		name            string
+		expectedContent string
+		expectedSource  vfs.Source
+	}{
+		{"textures/shared/wall.jpg", "from b", vfs.Source{Path: "data/vfs/B.pk3", Archive: true}},
+		{"textures/shared/only_a.tga", "loose", vfs.Source{Path: "data/vfs", Archive: false}},
+		{"textures/shared/floor.tga", "from b", vfs.Source{Path: "data/vfs/B.pk3", Archive: true}},
+		{"scripts/a.shader", "textures/shared/wall\n{\n}\n", vfs.Source{Path: "data/vfs/a.pk3", Archive: true}},
+	}
+	for _, test := range tests {
+		content, err := fs.ReadFile(files, test.name)
+		if err != nil {
+			t.Errorf("ReadFile returned error %s for %s", err, test.name)
+		}
+		if string(content) != test.expectedContent {
+			t.Errorf("Expected %q got %q for %s", test.expectedContent, content, test.name)
+		}
+		source, ok := files.Source(test.name)
+		if !ok || source != test.expectedSource {
+			t.Errorf("Expected source %v got %v for %s", test.expectedSource, source, test.name)
+		}
+	}
+
+	_, err = fs.ReadFile(files, "textures/shared/missing.jpg")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist got %v", err)
+	}
+	if _, ok := files.Source("textures/shared/missing.jpg"); ok {
+		t.Errorf("Expected no source for a missing file")
+	}
+}
+
+func TestVfsReadDir(t *testing.T) {
+	files, err := vfs.Open("data/vfs")
+	if err != nil {
+		t.Fatalf("Open returned error %s", err)
+	}
+	defer files.Close()
+
+	entries, err := fs.ReadDir(files, "textures/shared")
+	if err != nil {
+		t.Fatalf("ReadDir returned error %s", err)
+	}
+	actual := []string{}
+	for _, entry := range entries {
+		actual = append(actual, entry.Name())
+	}
+	expected := []string{"Floor.TGA", "only_a.tga", "wall.jpg"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}